@@ -6,6 +6,10 @@ import (
 	"github.com/circleci/ex/system"
 )
 
+// Load will create a new database connection pool, and wire it into the provided System with
+// default lifecycle management and observability. The pool is added as a named service
+// called "<dbName>-db" so that other services can depend on it, so each pool loaded into the
+// system needs its own dbName.
 func Load(ctx context.Context, dbName, appName string, cfg Config, sys *system.System) (*TxManager, error) {
 	db, err := New(ctx, dbName, appName, cfg)
 	if err != nil {
@@ -18,6 +22,10 @@ func Load(ctx context.Context, dbName, appName string, cfg Config, sys *system.S
 	sys.AddCleanup(func(ctx context.Context) error {
 		return db.Close()
 	})
+	sys.AddNamedService(system.Service{
+		Name: dbCheck.Name,
+		Run:  system.ReadyUntilDone,
+	})
 
 	return NewTxManager(db), nil
 }
@@ -0,0 +1,45 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// AuthProvider adds authentication to a request. It is called for every attempt, after all other
+// headers and the body have been set, so that providers can sign the request.
+//
+// The httpclient/auth package has providers for OAuth2 client credentials, HMAC and AWS SigV4.
+type AuthProvider interface {
+	Authenticate(ctx context.Context, req *http.Request) error
+}
+
+// Reauthenticator can be implemented by an AuthProvider that holds credentials that may expire
+// or be revoked. If a call fails with a 401 Reauthenticate is called, and the call is retried once.
+type Reauthenticator interface {
+	Reauthenticate(ctx context.Context) error
+}
+
+// reauthenticate reports whether the call should be retried after reauthenticating following a
+// 401 response.
+func (c *Client) reauthenticate(ctx context.Context, err error) bool {
+	if !HasStatusCode(err, http.StatusUnauthorized) {
+		return false
+	}
+	ra, ok := c.auth.(Reauthenticator)
+	if !ok {
+		return false
+	}
+	return ra.Reauthenticate(ctx) == nil
+}
+
+// authenticate calls the auth provider if there is one.
+func (c *Client) authenticate(ctx context.Context, req *http.Request) error {
+	if c.auth == nil {
+		return nil
+	}
+	if err := c.auth.Authenticate(ctx, req); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// hashBody returns the hex encoded hash of the request body. If the request cannot resend
+// its body it is read into memory, so that it can be sent after hashing.
+func hashBody(req *http.Request, h hash.Hash) (string, error) {
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+	case req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, body)
+		_ = body.Close()
+		if err != nil {
+			return "", err
+		}
+	default:
+		b, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write(b)
+		req.Body = io.NopCloser(bytes.NewReader(b))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,19 @@
+/*
+Package auth contains httpclient.AuthProvider implementations for OAuth2 client credentials,
+HMAC request signing and AWS SigV4 request signing.
+
+Credentials and tokens are held as secret.String so that they are not leaked into logs or spans.
+
+Example:
+
+	client := httpclient.New(httpclient.Config{
+	  Name:    "fruit",
+	  BaseURL: "https://fruit.example.com",
+	  Auth: auth.NewOAuth2(auth.OAuth2Config{
+	    TokenURL:     "https://auth.example.com/oauth/token",
+	    ClientID:     "fruit-client",
+	    ClientSecret: cfg.FruitClientSecret,
+	  }),
+	})
+*/
+package auth
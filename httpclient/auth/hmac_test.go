@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/httpclient"
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestHMAC(t *testing.T) {
+	ctx := testcontext.Background()
+
+	verify := func(r *http.Request) bool {
+		body, err := io.ReadAll(r.Body)
+		assert.Check(t, err)
+		bodyHash := sha256.Sum256(body)
+		mac := hmac.New(sha256.New, []byte("shared-key"))
+		mac.Write([]byte(StringToSign(r.Method, r.URL.RequestURI(), r.Header.Get("X-Signature-Timestamp"),
+			hex.EncodeToString(bodyHash[:]))))
+		want := "HMAC-SHA256 KeyId=key-1,Signature=" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		return r.Header.Get("Authorization") == want
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !verify(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewHMAC(HMACConfig{KeyID: "key-1", Key: "shared-key"})
+	client := httpclient.New(httpclient.Config{
+		BaseURL: server.URL,
+		Timeout: 5 * time.Second,
+		Auth:    provider,
+	})
+
+	t.Run("get", func(t *testing.T) {
+		err := client.Call(ctx, httpclient.NewRequest("GET", "/fruit", httpclient.QueryParam("colour", "red")))
+		assert.Check(t, err)
+	})
+
+	t.Run("json body", func(t *testing.T) {
+		err := client.Call(ctx, httpclient.NewRequest("POST", "/fruit", httpclient.Body(map[string]string{"name": "apple"})))
+		assert.Check(t, err)
+	})
+
+	t.Run("streamed body", func(t *testing.T) {
+		err := client.Call(ctx, httpclient.NewRequest("PUT", "/fruit",
+			httpclient.BodyReader(func() (io.Reader, error) {
+				return strings.NewReader("a streamed apple"), nil
+			}),
+		))
+		assert.Check(t, err)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		wrong := httpclient.New(httpclient.Config{
+			BaseURL: server.URL,
+			Auth:    NewHMAC(HMACConfig{KeyID: "key-1", Key: "wrong-key"}),
+		})
+		err := wrong.Call(ctx, httpclient.NewRequest("GET", "/fruit"))
+		assert.Check(t, httpclient.HasStatusCode(err, http.StatusUnauthorized), err)
+	})
+
+	t.Run("custom header", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/fruit", nil)
+		p := NewHMAC(HMACConfig{KeyID: "key-1", Key: "shared-key", Header: "X-Signature"})
+		p.now = func() time.Time { return time.Unix(1700000000, 0) }
+		assert.Check(t, p.Authenticate(ctx, req))
+		assert.Check(t, cmp.Equal(req.Header.Get("X-Signature-Timestamp"), "1700000000"))
+		assert.Check(t, strings.HasPrefix(req.Header.Get("X-Signature"), "HMAC-SHA256 KeyId=key-1,Signature="))
+		assert.Check(t, cmp.Equal(req.Header.Get("Authorization"), ""))
+	})
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/circleci/ex/config/secret"
+	"github.com/circleci/ex/httpclient"
+)
+
+// OAuth2Config configures an OAuth2 client credentials grant.
+type OAuth2Config struct {
+	// TokenURL is the URL of the authorization server's token endpoint.
+	TokenURL string
+	// ClientID and ClientSecret are sent to the token endpoint using HTTP basic auth.
+	ClientID     string
+	ClientSecret secret.String
+	// Scopes if set are requested for the token.
+	Scopes []string
+	// Params are any additional parameters to send to the token endpoint, such as an audience.
+	Params map[string]string
+	// RefreshBefore is how long before the token expires that it is refreshed, the default
+	// is 1 minute.
+	RefreshBefore time.Duration
+	// Timeout is the maximum time fetching a token can take including retries, the default
+	// is 10 seconds.
+	Timeout time.Duration
+}
+
+// OAuth2 is an auth provider that fetches tokens from an OAuth2 token endpoint using the client
+// credentials grant. The token is cached and refreshed before it expires.
+type OAuth2 struct {
+	cfg    OAuth2Config
+	client *httpclient.Client
+	route  string
+
+	mu      sync.Mutex
+	token   secret.String
+	tokType string
+	expiry  time.Time
+
+	now func() time.Time // purely a test hook
+}
+
+// NewOAuth2 creates an OAuth2 client credentials auth provider.
+func NewOAuth2(cfg OAuth2Config) *OAuth2 {
+	if cfg.RefreshBefore == 0 {
+		cfg.RefreshBefore = time.Minute
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	baseURL, route := cfg.TokenURL, ""
+	if u, err := url.Parse(cfg.TokenURL); err == nil {
+		route = u.RequestURI()
+		u.Path, u.RawPath, u.RawQuery = "", "", ""
+		baseURL = u.String()
+	}
+	return &OAuth2{
+		cfg: cfg,
+		client: httpclient.New(httpclient.Config{
+			Name:    "oauth2",
+			BaseURL: baseURL,
+			Timeout: cfg.Timeout,
+		}),
+		route: route,
+		now:   time.Now,
+	}
+}
+
+// Authenticate sets the bearer token on the request, fetching a new token if there is not one
+// or it is about to expire.
+func (o *OAuth2) Authenticate(ctx context.Context, req *http.Request) error {
+	tokType, token, err := o.get(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", tokType+" "+token.Raw())
+	return nil
+}
+
+// Reauthenticate discards the cached token, so that the next attempt fetches a new one.
+func (o *OAuth2) Reauthenticate(context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.token = ""
+	return nil
+}
+
+func (o *OAuth2) get(ctx context.Context) (string, secret.String, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := o.now()
+	if o.token != "" && now.Add(o.cfg.RefreshBefore).Before(o.expiry) {
+		return o.tokType, o.token, nil
+	}
+
+	err := o.fetch(ctx, now)
+	if err != nil {
+		// carry on using the current token if it has not expired yet
+		if o.token != "" && now.Before(o.expiry) {
+			return o.tokType, o.token, nil
+		}
+		return "", "", err
+	}
+	return o.tokType, o.token, nil
+}
+
+type tokenResponse struct {
+	AccessToken secret.String `json:"access_token"`
+	TokenType   string        `json:"token_type"`
+	ExpiresIn   int64         `json:"expires_in"`
+}
+
+func (o *OAuth2) fetch(ctx context.Context, now time.Time) error {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(o.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.cfg.Scopes, " "))
+	}
+	for k, v := range o.cfg.Params {
+		form.Set(k, v)
+	}
+
+	basic := &http.Request{Header: http.Header{}}
+	basic.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret.Raw()))
+
+	resp := tokenResponse{}
+	err := o.client.Call(ctx, httpclient.NewRequest("POST", o.route,
+		httpclient.FormBody(form),
+		httpclient.Header("Authorization", basic.Header.Get("Authorization")),
+		httpclient.JSONDecoder(&resp),
+	))
+	if err != nil {
+		return fmt.Errorf("oauth2 token: %w", err)
+	}
+	if resp.AccessToken == "" {
+		return errors.New("oauth2 token: no access token in response")
+	}
+
+	o.token = resp.AccessToken
+	o.tokType = resp.TokenType
+	// the token type is case-insensitive, but some servers only accept "Bearer"
+	if o.tokType == "" || strings.EqualFold(o.tokType, "bearer") {
+		o.tokType = "Bearer"
+	}
+	if resp.ExpiresIn > 0 {
+		o.expiry = now.Add(time.Duration(resp.ExpiresIn) * time.Second)
+	} else {
+		// without an expiry keep the token until the server rejects it
+		o.expiry = now.Add(100 * 365 * 24 * time.Hour)
+	}
+	return nil
+}
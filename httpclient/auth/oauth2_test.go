@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/httpclient"
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestOAuth2(t *testing.T) {
+	ctx := testcontext.Background()
+
+	var issued atomic.Int64
+	var revoked atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client-id" || secret != "client-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Check(t, cmp.Equal(r.PostFormValue("grant_type"), "client_credentials"))
+		assert.Check(t, cmp.Equal(r.PostFormValue("scope"), "read write"))
+		assert.Check(t, cmp.Equal(r.PostFormValue("audience"), "fruit"))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "token-" + strconv.FormatInt(issued.Add(1), 10),
+			"token_type":   "bearer",
+			"expires_in":   3600,
+		})
+	})
+	mux.HandleFunc("GET /fruit", func(w http.ResponseWriter, r *http.Request) {
+		want := "Bearer token-" + strconv.FormatInt(issued.Load(), 10)
+		if r.Header.Get("Authorization") != want || issued.Load() == revoked.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	provider := NewOAuth2(OAuth2Config{
+		TokenURL:     server.URL + "/oauth/token",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		Scopes:       []string{"read", "write"},
+		Params:       map[string]string{"audience": "fruit"},
+	})
+	now := time.Now()
+	provider.now = func() time.Time { return now }
+
+	client := httpclient.New(httpclient.Config{
+		BaseURL: server.URL,
+		Timeout: 5 * time.Second,
+		Auth:    provider,
+	})
+
+	t.Run("token is fetched and cached", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			assert.Check(t, client.Call(ctx, httpclient.NewRequest("GET", "/fruit")))
+		}
+		assert.Check(t, cmp.Equal(issued.Load(), int64(1)))
+	})
+
+	t.Run("token is refreshed before expiry", func(t *testing.T) {
+		now = now.Add(time.Hour - 30*time.Second)
+		assert.Check(t, client.Call(ctx, httpclient.NewRequest("GET", "/fruit")))
+		assert.Check(t, cmp.Equal(issued.Load(), int64(2)))
+	})
+
+	t.Run("revoked token is replaced", func(t *testing.T) {
+		revoked.Store(issued.Load())
+		assert.Check(t, client.Call(ctx, httpclient.NewRequest("GET", "/fruit")))
+		assert.Check(t, cmp.Equal(issued.Load(), int64(3)))
+	})
+
+	t.Run("token is not leaked", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(provider.token.Raw(), "token-3"))
+		assert.Check(t, cmp.Equal(fmt.Sprintf("%v", provider.token), "REDACTED"))
+	})
+
+	t.Run("bad client credentials", func(t *testing.T) {
+		bad := NewOAuth2(OAuth2Config{
+			TokenURL:     server.URL + "/oauth/token",
+			ClientID:     "client-id",
+			ClientSecret: "wrong",
+		})
+		err := bad.Authenticate(ctx, &http.Request{Header: http.Header{}})
+		assert.Check(t, httpclient.HasStatusCode(err, http.StatusUnauthorized), err)
+	})
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestSigV4(t *testing.T) {
+	ctx := testcontext.Background()
+	creds := aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}
+	signingTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	newProvider := func(unsigned bool) *SigV4 {
+		p := NewSigV4(SigV4Config{
+			Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+				return creds, nil
+			}),
+			Region:          "eu-west-1",
+			Service:         "execute-api",
+			UnsignedPayload: unsigned,
+		})
+		p.now = func() time.Time { return signingTime }
+		return p
+	}
+
+	t.Run("signs the request and body", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "https://api.example.com/fruit?colour=red", bytes.NewBufferString(`{"name":"apple"}`))
+		assert.Assert(t, newProvider(false).Authenticate(ctx, req))
+
+		auth := req.Header.Get("Authorization")
+		assert.Check(t, strings.HasPrefix(auth,
+			"AWS4-HMAC-SHA256 Credential=AKID/20240102/eu-west-1/execute-api/aws4_request"), auth)
+		assert.Check(t, cmp.Equal(req.Header.Get("X-Amz-Date"), "20240102T030405Z"))
+
+		// the signature matches signing the same request directly
+		want := httptest.NewRequest("POST", "https://api.example.com/fruit?colour=red", nil)
+		want.ContentLength = req.ContentLength
+		want.Header.Set("X-Amz-Content-Sha256", req.Header.Get("X-Amz-Content-Sha256"))
+		assert.Assert(t, v4.NewSigner().SignHTTP(ctx, creds, want, req.Header.Get("X-Amz-Content-Sha256"),
+			"execute-api", "eu-west-1", signingTime))
+		assert.Check(t, cmp.Equal(auth, want.Header.Get("Authorization")))
+
+		// the body can still be sent
+		var body bytes.Buffer
+		_, err := body.ReadFrom(req.Body)
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(body.String(), `{"name":"apple"}`))
+	})
+
+	t.Run("unsigned payload", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "https://bucket.s3.amazonaws.com/key", bytes.NewBufferString("data"))
+		assert.Assert(t, newProvider(true).Authenticate(ctx, req))
+		assert.Check(t, cmp.Equal(req.Header.Get("X-Amz-Content-Sha256"), "UNSIGNED-PAYLOAD"))
+	})
+
+	t.Run("credential errors", func(t *testing.T) {
+		p := NewSigV4(SigV4Config{
+			Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+				return aws.Credentials{}, errors.New("expired")
+			}),
+		})
+		err := p.Authenticate(ctx, httptest.NewRequest("GET", "https://api.example.com/", nil))
+		assert.Check(t, cmp.ErrorContains(err, "sigv4 credentials: expired"))
+	})
+}
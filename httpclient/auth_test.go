@@ -0,0 +1,88 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+type fakeAuth struct {
+	token           atomic.Int64
+	authenticated   atomic.Int64
+	reauthenticated atomic.Int64
+	err             error
+}
+
+func (f *fakeAuth) Authenticate(_ context.Context, req *http.Request) error {
+	f.authenticated.Add(1)
+	if f.err != nil {
+		return f.err
+	}
+	req.Header.Set("Authorization", "Bearer "+strconv.FormatInt(f.token.Load(), 10))
+	return nil
+}
+
+func (f *fakeAuth) Reauthenticate(context.Context) error {
+	f.reauthenticated.Add(1)
+	f.token.Add(1)
+	return nil
+}
+
+func TestClient_Call_Auth(t *testing.T) {
+	ctx := testcontext.Background()
+
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case calls.Add(1) == 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.Header.Get("Authorization") != "Bearer 1":
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	auth := &fakeAuth{}
+	client := New(Config{
+		BaseURL: server.URL,
+		Timeout: 5 * time.Second,
+		Auth:    auth,
+	})
+
+	t.Run("authenticated on each attempt and reauthenticated once on 401", func(t *testing.T) {
+		err := client.Call(ctx, NewRequest("GET", "/"))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(calls.Load(), int64(3)))
+		assert.Check(t, cmp.Equal(auth.authenticated.Load(), int64(3)))
+		assert.Check(t, cmp.Equal(auth.reauthenticated.Load(), int64(1)))
+	})
+
+	t.Run("only reauthenticated once", func(t *testing.T) {
+		calls.Store(1)
+		auth.token.Store(5)
+		auth.reauthenticated.Store(0)
+		err := client.Call(ctx, NewRequest("GET", "/"))
+		assert.Check(t, HasStatusCode(err, http.StatusUnauthorized), err)
+		assert.Check(t, cmp.Equal(auth.reauthenticated.Load(), int64(1)))
+	})
+
+	t.Run("auth errors are not retried", func(t *testing.T) {
+		calls.Store(1)
+		auth.authenticated.Store(0)
+		auth.err = errors.New("no credentials")
+		err := client.Call(ctx, NewRequest("GET", "/"))
+		assert.Check(t, cmp.ErrorContains(err, "auth: no credentials"))
+		assert.Check(t, cmp.Equal(auth.authenticated.Load(), int64(1)))
+		assert.Check(t, cmp.Equal(calls.Load(), int64(1)))
+	})
+}
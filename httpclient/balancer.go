@@ -0,0 +1,312 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/circleci/ex/httpclient/dnscache"
+)
+
+// ErrNoEndpoints is returned when there are no endpoints to balance calls across.
+var ErrNoEndpoints = errors.New("no endpoints")
+
+// BalancePolicy is how the client chooses between endpoints.
+type BalancePolicy int
+
+const (
+	// RoundRobin uses each endpoint in turn.
+	RoundRobin BalancePolicy = iota
+	// LeastOutstanding uses the endpoint with the fewest requests in flight.
+	LeastOutstanding
+)
+
+func (p BalancePolicy) String() string {
+	switch p {
+	case RoundRobin:
+		return "round-robin"
+	case LeastOutstanding:
+		return "least-outstanding"
+	}
+	return "unknown"
+}
+
+// EndpointsConfig configures the client to balance calls across a set of base URLs. An
+// attempt is counted as a failure of its endpoint in the same way as for the circuit breaker,
+// and retries and hedges prefer endpoints that have not yet been tried by the call.
+type EndpointsConfig struct {
+	// Static is a fixed set of base URLs.
+	Static []string
+	// Discover if set is called to find the current base URLs, instead of using Static.
+	// If it fails the previously discovered endpoints continue to be used.
+	// See SRVEndpoints and HostEndpoints.
+	Discover func(ctx context.Context) ([]string, error)
+	// RefreshInterval is how often Discover is called, the default is 5 seconds.
+	RefreshInterval time.Duration
+	// Policy is how an endpoint is chosen for each attempt, the default is RoundRobin.
+	Policy BalancePolicy
+	// ConsecutiveFailures ejects an endpoint after this many failures in a row, the default is 5.
+	ConsecutiveFailures int
+	// EjectionTime is how long an ejected endpoint is not used for, the default is 30 seconds.
+	EjectionTime time.Duration
+	// MaxEjectedPercent is the most of the endpoints (0 to 100) that can be ejected at once,
+	// the default is 50.
+	MaxEjectedPercent int
+}
+
+// SRVEndpoints discovers endpoints from the SRV records of the service, with base URLs of
+// the form scheme://target:port.
+//
+// Example:
+//
+//	client := httpclient.New(httpclient.Config{
+//	  Endpoints: &httpclient.EndpointsConfig{
+//	    Discover: httpclient.SRVEndpoints(resolver, "http", "api", "tcp", "example.com"),
+//	  },
+//	})
+func SRVEndpoints(resolver *dnscache.Resolver,
+	scheme, service, proto, name string) func(context.Context) ([]string, error) {
+	return func(ctx context.Context) ([]string, error) {
+		srvs, err := resolver.ResolveSRV(ctx, service, proto, name)
+		if err != nil {
+			return nil, err
+		}
+		urls := make([]string, len(srvs))
+		for i, srv := range srvs {
+			host := srv.Target
+			if len(host) > 1 && host[len(host)-1] == '.' {
+				host = host[:len(host)-1]
+			}
+			urls[i] = scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(srv.Port)))
+		}
+		return urls, nil
+	}
+}
+
+// HostEndpoints discovers endpoints from the addresses the host in the base URL resolves to,
+// giving a base URL for each address. As the host is replaced by an address this is not
+// suitable for servers that need the host name, for instance for TLS.
+func HostEndpoints(resolver *dnscache.Resolver, baseURL string) func(context.Context) ([]string, error) {
+	return func(ctx context.Context) ([]string, error) {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := resolver.Resolve(ctx, u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		urls := make([]string, len(ips))
+		for i, ip := range ips {
+			eu := *u
+			switch port := u.Port(); {
+			case port != "":
+				eu.Host = net.JoinHostPort(ip.String(), port)
+			case ip.To4() == nil:
+				eu.Host = "[" + ip.String() + "]"
+			default:
+				eu.Host = ip.String()
+			}
+			urls[i] = eu.String()
+		}
+		return urls, nil
+	}
+}
+
+type endpoint struct {
+	baseURL string
+
+	// the following are guarded by the balancer mutex
+	outstanding  int
+	failures     int
+	ejectedUntil time.Time
+}
+
+type balancer struct {
+	cfg EndpointsConfig
+	now func() time.Time
+
+	refreshMu   sync.Mutex
+	refreshedAt time.Time
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+}
+
+func newBalancer(cfg *EndpointsConfig, now func() time.Time) *balancer {
+	if cfg == nil {
+		return nil
+	}
+	c := *cfg
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = 5 * time.Second
+	}
+	if c.ConsecutiveFailures == 0 {
+		c.ConsecutiveFailures = 5
+	}
+	if c.EjectionTime == 0 {
+		c.EjectionTime = 30 * time.Second
+	}
+	if c.MaxEjectedPercent == 0 {
+		c.MaxEjectedPercent = 50
+	}
+	b := &balancer{cfg: c, now: now}
+	b.update(c.Static)
+	return b
+}
+
+// update replaces the endpoints, keeping the state of those that remain.
+func (b *balancer) update(urls []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	existing := make(map[string]*endpoint, len(b.endpoints))
+	for _, e := range b.endpoints {
+		existing[e.baseURL] = e
+	}
+	endpoints := make([]*endpoint, 0, len(urls))
+	for _, u := range urls {
+		e, ok := existing[u]
+		if !ok {
+			e = &endpoint{baseURL: u}
+		}
+		endpoints = append(endpoints, e)
+	}
+	b.endpoints = endpoints
+}
+
+// baseURLs returns the base URLs of the current endpoints.
+func (b *balancer) baseURLs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	urls := make([]string, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		urls = append(urls, e.baseURL)
+	}
+	return urls
+}
+
+// refresh discovers the endpoints if they are due to be refreshed. Only one call discovers
+// at a time, and others carry on with the current endpoints unless there are none.
+func (b *balancer) refresh(ctx context.Context) error {
+	if b.cfg.Discover == nil {
+		return nil
+	}
+	b.mu.Lock()
+	empty := len(b.endpoints) == 0
+	b.mu.Unlock()
+	if empty {
+		b.refreshMu.Lock()
+	} else if !b.refreshMu.TryLock() {
+		return nil
+	}
+	defer b.refreshMu.Unlock()
+
+	now := b.now()
+	if !empty && now.Sub(b.refreshedAt) < b.cfg.RefreshInterval {
+		return nil
+	}
+	// a failed discovery is not retried until the next refresh, unless there are no endpoints
+	b.refreshedAt = now
+	urls, err := b.cfg.Discover(ctx)
+	if err != nil {
+		return fmt.Errorf("discover endpoints: %w", err)
+	}
+	b.update(urls)
+	return nil
+}
+
+// pick chooses the endpoint for an attempt, preferring endpoints that are not ejected and
+// have not been tried.
+func (b *balancer) pick(ctx context.Context, tried map[*endpoint]bool) (*endpoint, error) {
+	refreshErr := b.refresh(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.endpoints) == 0 {
+		if refreshErr != nil {
+			return nil, refreshErr
+		}
+		return nil, ErrNoEndpoints
+	}
+
+	now := b.now()
+	healthy := func(e *endpoint) bool { return !now.Before(e.ejectedUntil) }
+	candidates := []func(*endpoint) bool{
+		func(e *endpoint) bool { return healthy(e) && !tried[e] },
+		healthy,
+		func(*endpoint) bool { return true },
+	}
+	for _, candidate := range candidates {
+		if e := b.choose(candidate); e != nil {
+			e.outstanding++
+			return e, nil
+		}
+	}
+	return nil, ErrNoEndpoints
+}
+
+// choose returns the candidate endpoint chosen by the policy, starting from the next endpoint
+// in turn so that ties are shared out.
+func (b *balancer) choose(candidate func(*endpoint) bool) *endpoint {
+	var chosen *endpoint
+	chosenAt := 0
+	for i := range b.endpoints {
+		idx := (b.next + i) % len(b.endpoints)
+		e := b.endpoints[idx]
+		if !candidate(e) {
+			continue
+		}
+		if chosen == nil || (b.cfg.Policy == LeastOutstanding && e.outstanding < chosen.outstanding) {
+			chosen, chosenAt = e, idx
+		}
+		if b.cfg.Policy == RoundRobin {
+			break
+		}
+	}
+	if chosen != nil {
+		b.next = chosenAt + 1
+	}
+	return chosen
+}
+
+// done records the outcome of an attempt made to the endpoint, ejecting it after too many
+// consecutive failures.
+func (b *balancer) done(e *endpoint, result outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.outstanding--
+	switch result {
+	case outcomeSuccess:
+		e.failures = 0
+		return
+	case outcomeIgnored:
+		return
+	}
+	e.failures++
+	if e.failures < b.cfg.ConsecutiveFailures {
+		return
+	}
+
+	now := b.now()
+	ejected := 0
+	for _, other := range b.endpoints {
+		if now.Before(other.ejectedUntil) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > len(b.endpoints)*b.cfg.MaxEjectedPercent {
+		return
+	}
+	e.failures = 0
+	e.ejectedUntil = now.Add(b.cfg.EjectionTime)
+}
@@ -0,0 +1,201 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+	"gotest.tools/v3/poll"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestClient_Call_Endpoints(t *testing.T) {
+	ctx := testcontext.Background()
+
+	type server struct {
+		url   string
+		calls atomic.Int64
+	}
+	newServer := func(t *testing.T, status int) *server {
+		s := &server{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			s.calls.Add(1)
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(srv.Close)
+		s.url = srv.URL
+		return s
+	}
+	calls := func(servers ...*server) []int64 {
+		n := make([]int64, len(servers))
+		for i, s := range servers {
+			n[i] = s.calls.Load()
+		}
+		return n
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		a, b, c := newServer(t, http.StatusOK), newServer(t, http.StatusOK), newServer(t, http.StatusOK)
+		client := New(Config{
+			Timeout: 5 * time.Second,
+			Endpoints: &EndpointsConfig{
+				Static: []string{a.url, b.url, c.url},
+			},
+		})
+		for range 6 {
+			assert.Check(t, client.Call(ctx, NewRequest("GET", "/")))
+		}
+		assert.Check(t, cmp.DeepEqual(calls(a, b, c), []int64{2, 2, 2}))
+	})
+
+	t.Run("retries prefer another endpoint", func(t *testing.T) {
+		bad, good := newServer(t, http.StatusServiceUnavailable), newServer(t, http.StatusOK)
+		client := New(Config{
+			Timeout: 5 * time.Second,
+			Endpoints: &EndpointsConfig{
+				Static:              []string{bad.url, good.url},
+				ConsecutiveFailures: 100,
+			},
+		})
+		for range 4 {
+			assert.Check(t, client.Call(ctx, NewRequest("GET", "/")))
+		}
+		// the retry of each call is made to the good endpoint
+		assert.Check(t, cmp.DeepEqual(calls(bad, good), []int64{4, 4}))
+	})
+
+	t.Run("outliers are ejected", func(t *testing.T) {
+		bad, good := newServer(t, http.StatusServiceUnavailable), newServer(t, http.StatusOK)
+		client := New(Config{
+			Timeout: 5 * time.Second,
+			Endpoints: &EndpointsConfig{
+				Static:              []string{bad.url, good.url},
+				ConsecutiveFailures: 2,
+			},
+		})
+		now := time.Now()
+		client.now = func() time.Time { return now }
+
+		for range 10 {
+			assert.Check(t, client.Call(ctx, NewRequest("GET", "/")))
+		}
+		assert.Check(t, cmp.DeepEqual(calls(bad, good), []int64{2, 10}))
+
+		now = now.Add(30 * time.Second)
+		for range 2 {
+			assert.Check(t, client.Call(ctx, NewRequest("GET", "/")))
+		}
+		// once the ejection has expired the endpoint is tried, and ejected again
+		assert.Check(t, cmp.DeepEqual(calls(bad, good), []int64{4, 12}))
+	})
+
+	t.Run("no more than half are ejected", func(t *testing.T) {
+		a, b := newServer(t, http.StatusServiceUnavailable), newServer(t, http.StatusServiceUnavailable)
+		client := New(Config{
+			Timeout: 5 * time.Second,
+			Endpoints: &EndpointsConfig{
+				Static:              []string{a.url, b.url},
+				ConsecutiveFailures: 1,
+			},
+		})
+		for range 4 {
+			err := client.Call(ctx, NewRequest("GET", "/", NoRetry()))
+			assert.Check(t, HasStatusCode(err, http.StatusServiceUnavailable))
+		}
+		assert.Check(t, cmp.DeepEqual(calls(a, b), []int64{1, 3}))
+	})
+
+	t.Run("least outstanding", func(t *testing.T) {
+		release := make(chan struct{})
+		var slowCalls atomic.Int64
+		slow := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			slowCalls.Add(1)
+			<-release
+		}))
+		t.Cleanup(slow.Close)
+		fast := newServer(t, http.StatusOK)
+
+		client := New(Config{
+			Timeout: 5 * time.Second,
+			Endpoints: &EndpointsConfig{
+				Static: []string{slow.URL, fast.url},
+				Policy: LeastOutstanding,
+			},
+		})
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Check(t, client.Call(ctx, NewRequest("GET", "/")))
+		}()
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			if slowCalls.Load() == 1 {
+				return poll.Success()
+			}
+			return poll.Continue("waiting for the slow call")
+		})
+
+		for range 5 {
+			assert.Check(t, client.Call(ctx, NewRequest("GET", "/")))
+		}
+		close(release)
+		wg.Wait()
+		assert.Check(t, cmp.Equal(slowCalls.Load(), int64(1)))
+		assert.Check(t, cmp.Equal(fast.calls.Load(), int64(5)))
+	})
+
+	t.Run("discovered", func(t *testing.T) {
+		a, b := newServer(t, http.StatusOK), newServer(t, http.StatusOK)
+		var discovered atomic.Int64
+		var failing atomic.Bool
+		client := New(Config{
+			Timeout: 5 * time.Second,
+			Endpoints: &EndpointsConfig{
+				Discover: func(context.Context) ([]string, error) {
+					discovered.Add(1)
+					if failing.Load() {
+						return nil, errors.New("lookup failed")
+					}
+					return []string{a.url, b.url}, nil
+				},
+			},
+		})
+		now := time.Now()
+		client.now = func() time.Time { return now }
+
+		for range 4 {
+			assert.Check(t, client.Call(ctx, NewRequest("GET", "/")))
+		}
+		assert.Check(t, cmp.DeepEqual(calls(a, b), []int64{2, 2}))
+		assert.Check(t, cmp.Equal(discovered.Load(), int64(1)))
+
+		failing.Store(true)
+		now = now.Add(5 * time.Second)
+		for range 2 {
+			assert.Check(t, client.Call(ctx, NewRequest("GET", "/")))
+		}
+		assert.Check(t, cmp.DeepEqual(calls(a, b), []int64{3, 3}))
+		assert.Check(t, cmp.Equal(discovered.Load(), int64(2)))
+	})
+
+	t.Run("discovery fails with no endpoints", func(t *testing.T) {
+		client := New(Config{
+			Endpoints: &EndpointsConfig{
+				Discover: func(context.Context) ([]string, error) {
+					return nil, nil
+				},
+			},
+		})
+		err := client.Call(ctx, NewRequest("GET", "/", NoRetry()))
+		assert.Check(t, errors.Is(err, ErrNoEndpoints))
+	})
+}
@@ -0,0 +1,299 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/circleci/ex/system"
+)
+
+// ErrCircuitOpen is returned without calling the server while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed allows all requests.
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen allows a limited number of trial requests to see if the server has recovered.
+	BreakerHalfOpen
+	// BreakerOpen rejects all requests with ErrCircuitOpen.
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig configures the client circuit breaker. An attempt is counted as a failure if
+// the server could not be reached, did not respond in time, or responded with a 5XX or 429.
+type BreakerConfig struct {
+	// PerRoute gives each request route its own breaker, rather than one for the whole client.
+	PerRoute bool
+	// ConsecutiveFailures trips the breaker after this many failures in a row, the default is 5.
+	ConsecutiveFailures int
+	// ErrorRate if set trips the breaker once this proportion (0 to 1) of the attempts in the
+	// current Window have failed.
+	ErrorRate float64
+	// MinRequests is the number of attempts needed in the Window before the ErrorRate is
+	// checked, the default is 10.
+	MinRequests int
+	// Window is the period the ErrorRate is measured over, the default is 10 seconds.
+	Window time.Duration
+	// OpenTimeout is how long the breaker stays open before allowing trial requests, the default
+	// is 30 seconds.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of trial requests that must succeed to close the breaker,
+	// the default is 1.
+	HalfOpenRequests int
+}
+
+// CircuitBreaker tracks the breakers for a client. It satisfies system.GaugeProducer, reporting
+// the state of each breaker as 0 (closed), 1 (half-open) or 2 (open).
+type CircuitBreaker struct {
+	client string
+	cfg    *BreakerConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+func newCircuitBreaker(client string, cfg *BreakerConfig, now func() time.Time) *CircuitBreaker {
+	if cfg != nil {
+		c := *cfg
+		if c.ConsecutiveFailures == 0 {
+			c.ConsecutiveFailures = 5
+		}
+		if c.MinRequests == 0 {
+			c.MinRequests = 10
+		}
+		if c.Window == 0 {
+			c.Window = 10 * time.Second
+		}
+		if c.OpenTimeout == 0 {
+			c.OpenTimeout = 30 * time.Second
+		}
+		if c.HalfOpenRequests == 0 {
+			c.HalfOpenRequests = 1
+		}
+		cfg = &c
+	}
+	return &CircuitBreaker{
+		client:   client,
+		cfg:      cfg,
+		now:      now,
+		breakers: map[string]*breaker{},
+	}
+}
+
+// get returns the breaker for the route, or nil if the circuit breaker is not enabled.
+func (cb *CircuitBreaker) get(route string) *breaker {
+	if cb.cfg == nil {
+		return nil
+	}
+	key := cb.client
+	if cb.cfg.PerRoute {
+		key = route
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	b, ok := cb.breakers[key]
+	if !ok {
+		b = &breaker{
+			key:         key,
+			cfg:         cb.cfg,
+			now:         cb.now,
+			windowStart: cb.now(),
+		}
+		cb.breakers[key] = b
+	}
+	return b
+}
+
+// State returns the current state of the breaker used for the route.
+func (cb *CircuitBreaker) State(route string) BreakerState {
+	b := cb.get(route)
+	if b == nil {
+		return BreakerClosed
+	}
+	return b.currentState()
+}
+
+// GaugeName returns the name for the gauges. (satisfies system.GaugeProducer)
+func (cb *CircuitBreaker) GaugeName() string {
+	return "httpclient_circuit_breaker"
+}
+
+// Gauges returns the state of every breaker, and the number of times each has tripped.
+// (satisfies system.GaugeProducer)
+func (cb *CircuitBreaker) Gauges(_ context.Context) map[string][]system.TaggedValue {
+	cb.mu.Lock()
+	breakers := make([]*breaker, 0, len(cb.breakers))
+	for _, b := range cb.breakers {
+		breakers = append(breakers, b)
+	}
+	cb.mu.Unlock()
+
+	gauges := map[string][]system.TaggedValue{}
+	for _, b := range breakers {
+		tags := []string{"client:" + cb.client, "breaker:" + b.key}
+		b.mu.Lock()
+		state := b.stateLocked()
+		trips := b.trips
+		b.mu.Unlock()
+		gauges["state"] = append(gauges["state"], system.TaggedValue{Val: float64(state), Tags: tags})
+		gauges["trips"] = append(gauges["trips"], system.TaggedValue{Val: float64(trips), Tags: tags})
+	}
+	return gauges
+}
+
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeIgnored is used when the attempt said nothing about the server, for instance if the
+	// caller cancelled the request.
+	outcomeIgnored
+)
+
+type breaker struct {
+	key string
+	cfg *BreakerConfig
+	now func() time.Time
+
+	mu          sync.Mutex
+	state       BreakerState
+	openedAt    time.Time
+	consecutive int
+	windowStart time.Time
+	requests    int
+	failures    int
+	trials      int
+	successes   int
+	trips       int
+	// generation changes with every change of state, so that the outcomes of attempts allowed
+	// in an earlier state are not counted in the current one
+	generation uint64
+}
+
+// allow returns ErrCircuitOpen if the request should not be made, along with the breaker state.
+// The returned generation must be passed to record with the outcome of the attempt.
+func (b *breaker) allow() (BreakerState, uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.stateLocked()
+	switch state {
+	case BreakerOpen:
+		return state, b.generation, ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.state == BreakerOpen {
+			// the open timeout has elapsed, so start the trial requests
+			b.state = BreakerHalfOpen
+			b.generation++
+			b.trials = 0
+			b.successes = 0
+		}
+		if b.trials >= b.cfg.HalfOpenRequests {
+			return state, b.generation, ErrCircuitOpen
+		}
+		b.trials++
+	}
+	return state, b.generation, nil
+}
+
+// record updates the breaker with the outcome of an attempt allowed in the generation. Outcomes
+// from an earlier generation are dropped, so for instance a slow success that was allowed while
+// the breaker was closed does not count as a trial once it is half-open.
+func (b *breaker) record(generation uint64, o outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+	if b.state == BreakerHalfOpen {
+		switch o {
+		case outcomeIgnored:
+			b.trials--
+		case outcomeFailure:
+			b.trip()
+		case outcomeSuccess:
+			b.successes++
+			if b.successes >= b.cfg.HalfOpenRequests {
+				b.reset()
+			}
+		}
+		return
+	}
+	if b.state != BreakerClosed || o == outcomeIgnored {
+		return
+	}
+
+	now := b.now()
+	if now.Sub(b.windowStart) >= b.cfg.Window {
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+	}
+	b.requests++
+	if o == outcomeSuccess {
+		b.consecutive = 0
+		return
+	}
+	b.failures++
+	b.consecutive++
+
+	if b.consecutive >= b.cfg.ConsecutiveFailures {
+		b.trip()
+		return
+	}
+	if b.cfg.ErrorRate > 0 && b.requests >= b.cfg.MinRequests &&
+		float64(b.failures)/float64(b.requests) >= b.cfg.ErrorRate {
+		b.trip()
+	}
+}
+
+func (b *breaker) currentState() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stateLocked()
+}
+
+// stateLocked returns the effective state, an open breaker is half-open once the timeout has elapsed.
+func (b *breaker) stateLocked() BreakerState {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+func (b *breaker) trip() {
+	b.state = BreakerOpen
+	b.generation++
+	b.openedAt = b.now()
+	b.trips++
+}
+
+func (b *breaker) reset() {
+	b.state = BreakerClosed
+	b.generation++
+	b.consecutive = 0
+	b.windowStart = b.now()
+	b.requests = 0
+	b.failures = 0
+}
@@ -0,0 +1,168 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/system"
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+var _ system.GaugeProducer = (*CircuitBreaker)(nil)
+
+func TestBreaker_ConsecutiveFailures(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker("client", &BreakerConfig{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         time.Minute,
+		HalfOpenRequests:    2,
+	}, func() time.Time { return now })
+	b := cb.get("/route")
+
+	attempt := func(o outcome) error {
+		_, generation, err := b.allow()
+		if err != nil {
+			return err
+		}
+		b.record(generation, o)
+		return nil
+	}
+
+	assert.Check(t, attempt(outcomeFailure))
+	assert.Check(t, attempt(outcomeFailure))
+	assert.Check(t, attempt(outcomeSuccess))
+	assert.Check(t, attempt(outcomeFailure))
+	assert.Check(t, attempt(outcomeIgnored))
+	assert.Check(t, attempt(outcomeFailure))
+	assert.Check(t, cmp.Equal(cb.State("/route"), BreakerClosed))
+
+	assert.Check(t, attempt(outcomeFailure))
+	assert.Check(t, cmp.Equal(cb.State("/route"), BreakerOpen))
+	assert.Check(t, cmp.ErrorIs(attempt(outcomeSuccess), ErrCircuitOpen))
+
+	t.Run("half-open failure reopens", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		assert.Check(t, cmp.Equal(cb.State("/route"), BreakerHalfOpen))
+		assert.Check(t, attempt(outcomeFailure))
+		assert.Check(t, cmp.Equal(cb.State("/route"), BreakerOpen))
+	})
+
+	t.Run("half-open limits trials", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		state, generation, err := b.allow()
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(state, BreakerHalfOpen))
+		_, _, err = b.allow()
+		assert.Check(t, err)
+		_, _, err = b.allow()
+		assert.Check(t, cmp.ErrorIs(err, ErrCircuitOpen))
+
+		b.record(generation, outcomeSuccess)
+		assert.Check(t, cmp.Equal(cb.State("/route"), BreakerHalfOpen))
+		b.record(generation, outcomeSuccess)
+		assert.Check(t, cmp.Equal(cb.State("/route"), BreakerClosed))
+	})
+
+	t.Run("gauges", func(t *testing.T) {
+		tags := []string{"client:client", "breaker:client"}
+		assert.Check(t, cmp.DeepEqual(cb.Gauges(context.Background()), map[string][]system.TaggedValue{
+			"state": {{Val: 0, Tags: tags}},
+			"trips": {{Val: 2, Tags: tags}},
+		}))
+	})
+
+	t.Run("outcomes from an earlier state are dropped", func(t *testing.T) {
+		_, closedGeneration, err := b.allow()
+		assert.Assert(t, err)
+		for range 3 {
+			assert.Check(t, attempt(outcomeFailure))
+		}
+		assert.Check(t, cmp.Equal(cb.State("/route"), BreakerOpen))
+
+		now = now.Add(time.Minute)
+		_, _, err = b.allow()
+		assert.Check(t, err)
+		// a late success does not close the breaker, and a late ignored outcome does not free
+		// a trial
+		b.record(closedGeneration, outcomeSuccess)
+		b.record(closedGeneration, outcomeSuccess)
+		b.record(closedGeneration, outcomeIgnored)
+		assert.Check(t, cmp.Equal(cb.State("/route"), BreakerHalfOpen))
+		_, _, err = b.allow()
+		assert.Check(t, err)
+		_, _, err = b.allow()
+		assert.Check(t, cmp.ErrorIs(err, ErrCircuitOpen))
+	})
+}
+
+func TestBreaker_ErrorRate(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker("client", &BreakerConfig{
+		PerRoute:            true,
+		ConsecutiveFailures: 100,
+		ErrorRate:           0.5,
+		MinRequests:         4,
+		Window:              time.Second,
+	}, func() time.Time { return now })
+
+	a := cb.get("/a")
+	for _, o := range []outcome{outcomeFailure, outcomeSuccess, outcomeFailure} {
+		_, generation, err := a.allow()
+		assert.Assert(t, err)
+		a.record(generation, o)
+	}
+	assert.Check(t, cmp.Equal(cb.State("/a"), BreakerClosed))
+
+	t.Run("the window resets", func(t *testing.T) {
+		now = now.Add(time.Second)
+		a.record(0, outcomeFailure)
+		assert.Check(t, cmp.Equal(cb.State("/a"), BreakerClosed))
+	})
+
+	t.Run("trips at the rate", func(t *testing.T) {
+		a.record(0, outcomeSuccess)
+		a.record(0, outcomeSuccess)
+		a.record(0, outcomeFailure)
+		assert.Check(t, cmp.Equal(cb.State("/a"), BreakerOpen))
+	})
+
+	t.Run("routes are independent", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(cb.State("/b"), BreakerClosed))
+	})
+}
+
+func TestClient_Call_CircuitBreaker(t *testing.T) {
+	ctx := testcontext.Background()
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	client := New(Config{
+		Name:    "breaker",
+		BaseURL: server.URL,
+		Timeout: time.Second,
+		CircuitBreaker: &BreakerConfig{
+			ConsecutiveFailures: 3,
+		},
+	})
+
+	err := client.Call(ctx, NewRequest("GET", "/"))
+	assert.Check(t, errors.Is(err, ErrCircuitOpen), err)
+	assert.Check(t, cmp.Equal(calls.Load(), int64(3)))
+	assert.Check(t, cmp.Equal(client.CircuitBreaker().State("/"), BreakerOpen))
+
+	err = client.Call(ctx, NewRequest("GET", "/"))
+	assert.Check(t, errors.Is(err, ErrCircuitOpen), err)
+	assert.Check(t, cmp.Equal(calls.Load(), int64(3)))
+}
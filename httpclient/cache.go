@@ -0,0 +1,273 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/go-tinylfu"
+
+	"github.com/circleci/ex/o11y"
+)
+
+const (
+	cacheHit         = "hit"
+	cacheMiss        = "miss"
+	cacheRevalidated = "revalidated"
+)
+
+// CacheConfig configures the client side response cache.
+type CacheConfig struct {
+	// Size is the maximum number of responses cached, the default is 1000.
+	Size int
+	// MaxBodySize is the largest response body that will be cached, the default is 1MiB.
+	MaxBodySize int64
+}
+
+// responseCache is a private HTTP cache of successful GET responses. Fresh responses, according
+// to their Cache-Control or Expires headers, are served without calling the server. Stale
+// responses with an ETag or Last-Modified header are revalidated with a conditional request.
+type responseCache struct {
+	cfg CacheConfig
+
+	mu  sync.Mutex
+	lfu *tinylfu.T
+}
+
+type cacheEntry struct {
+	header     http.Header
+	body       []byte
+	vary       map[string]string // the request header values named by the Vary header
+	freshUntil time.Time
+}
+
+func newResponseCache(cfg *CacheConfig) *responseCache {
+	if cfg == nil {
+		return nil
+	}
+	c := *cfg
+	if c.Size == 0 {
+		c.Size = 1000
+	}
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = 1 << 20
+	}
+	return &responseCache{
+		cfg: c,
+		lfu: tinylfu.New(c.Size, 100000),
+	}
+}
+
+// cacheable reports whether the request can use the cache.
+func (rc *responseCache) cacheable(r Request) bool {
+	if rc == nil || r.method != http.MethodGet || r.stream != nil {
+		return false
+	}
+	_, noStore := parseCacheControl(r.headers["Cache-Control"])["no-store"]
+	return !noStore
+}
+
+// get returns the cached entry for the request if there is one, and whether it is fresh.
+func (rc *responseCache) get(r Request, req *http.Request, now time.Time) (*cacheEntry, bool) {
+	rc.mu.Lock()
+	v, ok := rc.lfu.Get(req.URL.String())
+	rc.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	e := v.(*cacheEntry)
+	for h, val := range e.vary {
+		if req.Header.Get(h) != val {
+			return nil, false
+		}
+	}
+	_, noCache := parseCacheControl(r.headers["Cache-Control"])["no-cache"]
+	return e, !noCache && now.Before(e.freshUntil)
+}
+
+// response handles the response to a cacheable request. A 304 for a revalidated entry is
+// replaced with the cached response, and cacheable 200 responses are stored.
+func (rc *responseCache) response(req *http.Request, res *http.Response, cached *cacheEntry,
+	now time.Time) (*http.Response, string) {
+	switch {
+	case res.StatusCode == http.StatusNotModified && cached != nil:
+		header := cached.header.Clone()
+		for k, v := range res.Header {
+			header[k] = v
+		}
+		e := &cacheEntry{header: header, body: cached.body, vary: cached.vary}
+		if lifetime, ok := freshness(header, now); ok {
+			e.freshUntil = now.Add(lifetime)
+			rc.set(req, e)
+		}
+		return e.response(res), cacheRevalidated
+	case res.StatusCode == http.StatusOK:
+		lifetime, ok := freshness(res.Header, now)
+		if !ok {
+			return res, cacheMiss
+		}
+		body, err := io.ReadAll(io.LimitReader(res.Body, rc.cfg.MaxBodySize+1))
+		stored := &http.Response{
+			StatusCode: res.StatusCode,
+			Header:     res.Header,
+			Body: readCloser{
+				Reader: io.MultiReader(bytes.NewReader(body), res.Body),
+				Closer: res.Body,
+			},
+		}
+		if err != nil || int64(len(body)) > rc.cfg.MaxBodySize {
+			return stored, cacheMiss
+		}
+		e := &cacheEntry{
+			header:     res.Header.Clone(),
+			body:       body,
+			freshUntil: now.Add(lifetime),
+		}
+		for _, h := range res.Header.Values("Vary") {
+			for _, name := range strings.Split(h, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					if e.vary == nil {
+						e.vary = map[string]string{}
+					}
+					e.vary[name] = req.Header.Get(name)
+				}
+			}
+		}
+		rc.set(req, e)
+		return stored, cacheMiss
+	}
+	return res, cacheMiss
+}
+
+func (rc *responseCache) set(req *http.Request, e *cacheEntry) {
+	item := &tinylfu.Item{
+		Key:   req.URL.String(),
+		Value: e,
+	}
+	// responses that cannot be revalidated are of no use once they are stale
+	if e.header.Get("ETag") == "" && e.header.Get("Last-Modified") == "" {
+		item.ExpireAt = e.freshUntil
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	// the cache does not replace existing items, so a revalidated response must be removed first
+	rc.lfu.Del(item.Key)
+	rc.lfu.Set(item)
+}
+
+// addConditions makes the request conditional on the cached response having changed.
+func (e *cacheEntry) addConditions(req *http.Request) {
+	if etag := e.header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.header.Get("Last-Modified"); lm != "" {
+		req.Header.Set("If-Modified-Since", lm)
+	}
+}
+
+// response returns the cached response, closing the original response body if there is one.
+func (e *cacheEntry) response(orig *http.Response) *http.Response {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     e.header.Clone(),
+		Body:       io.NopCloser(bytes.NewReader(e.body)),
+	}
+	if orig != nil {
+		res.Body = readCloser{Reader: bytes.NewReader(e.body), Closer: orig.Body}
+	}
+	return res
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// freshness returns how long the response is fresh for, and whether it can be stored at all.
+func freshness(h http.Header, now time.Time) (time.Duration, bool) {
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return 0, false
+	}
+	if strings.TrimSpace(h.Get("Vary")) == "*" {
+		return 0, false
+	}
+
+	var lifetime time.Duration
+	_, noCache := cc["no-cache"]
+	switch {
+	case noCache:
+	case cc["max-age"] != "":
+		secs, err := strconv.Atoi(cc["max-age"])
+		if err == nil {
+			lifetime = time.Duration(secs) * time.Second
+		}
+	case h.Get("Expires") != "":
+		expires, err := http.ParseTime(h.Get("Expires"))
+		if err == nil {
+			date, err := http.ParseTime(h.Get("Date"))
+			if err != nil {
+				date = now
+			}
+			lifetime = expires.Sub(date)
+		}
+	}
+	if age, err := strconv.Atoi(h.Get("Age")); err == nil {
+		lifetime -= time.Duration(age) * time.Second
+	}
+	lifetime = max(lifetime, 0)
+
+	revalidatable := h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+	return lifetime, lifetime > 0 || revalidatable
+}
+
+func parseCacheControl(v string) map[string]string {
+	cc := map[string]string{}
+	for _, directive := range strings.Split(v, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "" {
+			continue
+		}
+		k, val, _ := strings.Cut(directive, "=")
+		cc[strings.ToLower(strings.TrimSpace(k))] = strings.Trim(strings.TrimSpace(val), `"`)
+	}
+	return cc
+}
+
+// cacheHit serves a fresh cached response without calling the server.
+func (c *Client) cacheHit(ctx context.Context, name string, r Request, e *cacheEntry) (err error) {
+	ctx, span := o11y.StartSpan(ctx, name, o11y.WithSpanKind(o11y.SpanKindClient))
+	defer o11y.End(span, &err)
+	if r.flatten != "" {
+		span.Flatten("hc_" + r.flatten)
+	}
+	span.AddRawField("http.client_name", c.name)
+	span.AddRawField("http.route", r.route)
+	span.AddRawField("http.method", r.method)
+	span.AddRawField("http.cache", cacheHit)
+	c.recordCache(ctx, r, cacheHit)
+
+	res := e.response(nil)
+	if r.headerFn != nil {
+		r.headerFn(res.Header)
+	}
+	return r.decodeBody(res, true)
+}
+
+func (c *Client) recordCache(ctx context.Context, r Request, result string) {
+	m := o11y.FromContext(ctx).MetricsProvider()
+	if m == nil {
+		return
+	}
+	_ = m.Count("httpclient.cache", 1, []string{
+		"http.client_name:" + c.name,
+		"http.route:" + r.route,
+		"http.cache:" + result,
+	}, 1)
+}
@@ -0,0 +1,162 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/o11y"
+	"github.com/circleci/ex/o11y/otel"
+	"github.com/circleci/ex/testing/fakemetrics"
+)
+
+func TestClient_Call_Cache(t *testing.T) {
+	m := &fakemetrics.Provider{}
+	p, err := otel.New(otel.Config{Metrics: m})
+	assert.NilError(t, err)
+	ctx := o11y.WithProvider(context.Background(), p)
+
+	var calls atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /max-age", func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "fresh")
+	})
+	mux.HandleFunc("GET /etag", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = io.WriteString(w, "tagged")
+	})
+	mux.HandleFunc("GET /no-store", func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "no-store, max-age=60")
+		_, _ = io.WriteString(w, "secret")
+	})
+	mux.HandleFunc("GET /vary", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = io.WriteString(w, r.Header.Get("Accept-Language"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := New(Config{
+		Name:    "cache-test",
+		BaseURL: server.URL,
+		Timeout: 5 * time.Second,
+		Cache:   &CacheConfig{Size: 10},
+	})
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	get := func(t *testing.T, route string, opts ...func(*Request)) string {
+		t.Helper()
+		var body string
+		err := client.Call(ctx, NewRequest("GET", route, append(opts, StringDecoder(&body))...))
+		assert.Check(t, err)
+		return body
+	}
+	cacheMetrics := func(result string) int {
+		n := 0
+		for _, c := range m.Calls() {
+			if c.Name == "httpclient.cache" && slices.Contains(c.Tags, "http.cache:"+result) {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("fresh responses are served from the cache", func(t *testing.T) {
+		calls.Store(0)
+		assert.Check(t, cmp.Equal(get(t, "/max-age"), "fresh"))
+		assert.Check(t, cmp.Equal(get(t, "/max-age"), "fresh"))
+		assert.Check(t, cmp.Equal(calls.Load(), int64(1)))
+
+		now = now.Add(time.Minute)
+		assert.Check(t, cmp.Equal(get(t, "/max-age"), "fresh"))
+		assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+
+		// the refetched response replaces the stale one
+		assert.Check(t, cmp.Equal(get(t, "/max-age"), "fresh"))
+		assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+		assert.Check(t, cmp.Equal(cacheMetrics(cacheHit), 2))
+	})
+
+	t.Run("stale responses are revalidated", func(t *testing.T) {
+		calls.Store(0)
+		assert.Check(t, cmp.Equal(get(t, "/etag"), "tagged"))
+		assert.Check(t, cmp.Equal(get(t, "/etag"), "tagged"))
+		assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+		assert.Check(t, cmp.Equal(cacheMetrics(cacheRevalidated), 1))
+	})
+
+	t.Run("no-store responses are not cached", func(t *testing.T) {
+		calls.Store(0)
+		assert.Check(t, cmp.Equal(get(t, "/no-store"), "secret"))
+		assert.Check(t, cmp.Equal(get(t, "/no-store"), "secret"))
+		assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+	})
+
+	t.Run("no-store requests skip the cache", func(t *testing.T) {
+		calls.Store(0)
+		assert.Check(t, cmp.Equal(get(t, "/max-age", Header("Cache-Control", "no-store")), "fresh"))
+		assert.Check(t, cmp.Equal(calls.Load(), int64(1)))
+	})
+
+	t.Run("vary", func(t *testing.T) {
+		calls.Store(0)
+		assert.Check(t, cmp.Equal(get(t, "/vary", Header("Accept-Language", "en")), "en"))
+		assert.Check(t, cmp.Equal(get(t, "/vary", Header("Accept-Language", "en")), "en"))
+		assert.Check(t, cmp.Equal(get(t, "/vary", Header("Accept-Language", "fr")), "fr"))
+		assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+	})
+}
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		header    http.Header
+		want      time.Duration
+		wantStore bool
+	}{
+		{name: "nothing", header: http.Header{}, wantStore: false},
+		{name: "max-age", header: http.Header{"Cache-Control": {"public, max-age=60"}}, want: time.Minute, wantStore: true},
+		{name: "age", header: http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}}, want: 40 * time.Second, wantStore: true},
+		{name: "no-cache", header: http.Header{"Cache-Control": {"no-cache, max-age=60"}}, wantStore: false},
+		{name: "no-cache etag", header: http.Header{"Cache-Control": {"no-cache"}, "Etag": {`"a"`}}, wantStore: true},
+		{name: "no-store", header: http.Header{"Cache-Control": {"no-store"}, "Etag": {`"a"`}}, wantStore: false},
+		{name: "vary star", header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, wantStore: false},
+		{
+			name: "expires",
+			header: http.Header{
+				"Expires": {now.Add(time.Hour).Format(http.TimeFormat)},
+				"Date":    {now.Format(http.TimeFormat)},
+			},
+			want:      time.Hour,
+			wantStore: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, store := freshness(tt.header, now)
+			assert.Check(t, cmp.Equal(got, tt.want))
+			assert.Check(t, cmp.Equal(store, tt.wantStore))
+		})
+	}
+}
@@ -1,20 +1,29 @@
 /*
 Package dnscache contains a simple in-process cache for DNS lookups
+
+Entries are refreshed in the background shortly before they expire, and an expired entry is
+served while it is being refreshed, or for up to StaleTTL if the resolver is failing.
 */
 package dnscache
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/vmihailenco/go-tinylfu"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/circleci/ex/system"
 )
 
 const (
-	defaultCacheSize = 64
-	defaultTTL       = 5 * time.Second
+	defaultCacheSize     = 64
+	defaultTTL           = 5 * time.Second
+	defaultLookupTimeout = 10 * time.Second
 )
 
 func defaultLookupFunc(ctx context.Context, r *net.Resolver, host string) ([]net.IP, error) {
@@ -31,22 +40,61 @@ func defaultLookupFunc(ctx context.Context, r *net.Resolver, host string) ([]net
 	return ips, nil
 }
 
+func defaultLookupSRVFunc(ctx context.Context, r *net.Resolver, service, proto, name string) ([]*net.SRV, error) {
+	_, srvs, err := r.LookupSRV(ctx, service, proto, name)
+	return srvs, err
+}
+
 type Resolver struct {
 	config Config
 
 	// mutable state
-	mu    sync.Mutex
-	cache *tinylfu.T
+	mu      sync.Mutex
+	cache   *tinylfu.T
+	lookups singleflight.Group
+
+	hits     atomic.Int64
+	misses   atomic.Int64
+	stale    atomic.Int64
+	negative atomic.Int64
+	failures atomic.Int64
 }
 
 type Config struct {
 	CacheSize int
 	TTL       time.Duration
 
+	// RefreshBefore is how long before an entry expires that it is refreshed in the background
+	// by the next lookup for it. It defaults to a fifth of the TTL.
+	RefreshBefore time.Duration
+
+	// StaleTTL is how long after an entry has expired that it may still be served while it is
+	// refreshed, including while the resolver is returning errors. Zero disables serving stale
+	// entries.
+	StaleTTL time.Duration
+
+	// NegativeTTL is how long a host that does not exist is cached for. Zero disables
+	// negative caching.
+	NegativeTTL time.Duration
+
+	// Name is added as a tag to the gauges, to distinguish multiple resolvers.
+	Name string
+
 	// Resolver optionally allows specifying a custom resolver
 	Resolver *net.Resolver
 
-	lookupFunc func(ctx context.Context, r *net.Resolver, host string) ([]net.IP, error)
+	lookupFunc    func(ctx context.Context, r *net.Resolver, host string) ([]net.IP, error)
+	lookupSRVFunc func(ctx context.Context, r *net.Resolver, service, proto, name string) ([]*net.SRV, error)
+	now           func() time.Time
+}
+
+type entry struct {
+	value     any
+	err       error
+	expireAt  time.Time
+	refreshAt time.Time
+	// evictAt is when the entry can no longer be served stale
+	evictAt time.Time
 }
 
 func New(c Config) *Resolver {
@@ -58,6 +106,10 @@ func New(c Config) *Resolver {
 		c.TTL = defaultTTL
 	}
 
+	if c.RefreshBefore == 0 {
+		c.RefreshBefore = c.TTL / 5
+	}
+
 	if c.Resolver == nil {
 		c.Resolver = net.DefaultResolver
 	}
@@ -66,41 +118,174 @@ func New(c Config) *Resolver {
 		c.lookupFunc = defaultLookupFunc
 	}
 
+	if c.lookupSRVFunc == nil {
+		c.lookupSRVFunc = defaultLookupSRVFunc
+	}
+
+	if c.now == nil {
+		c.now = time.Now
+	}
+
 	return &Resolver{
 		config: c,
 		cache:  tinylfu.New(c.CacheSize, 100000),
 	}
 }
 
+// Resolve returns the IP addresses of the host.
 func (r *Resolver) Resolve(ctx context.Context, addr string) ([]net.IP, error) {
-	v, ok := r.cacheGet(addr)
-	if ok {
-		return v.([]net.IP), nil
+	v, err := r.resolve(ctx, addr, func(ctx context.Context) (any, error) {
+		return r.config.lookupFunc(ctx, r.config.Resolver, addr)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return v.([]net.IP), nil
+}
 
-	ips, err := r.config.lookupFunc(ctx, r.config.Resolver, addr)
+// ResolveSRV returns the SRV records of the service, sorted by priority and randomized by
+// weight, as described by net.LookupSRV.
+func (r *Resolver) ResolveSRV(ctx context.Context, service, proto, name string) ([]*net.SRV, error) {
+	key := "_" + service + "._" + proto + "." + name
+	if service == "" && proto == "" {
+		key = name
+	}
+	v, err := r.resolve(ctx, "srv:"+key, func(ctx context.Context) (any, error) {
+		return r.config.lookupSRVFunc(ctx, r.config.Resolver, service, proto, name)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return v.([]*net.SRV), nil
+}
 
-	r.cacheSet(&tinylfu.Item{
-		Key:      addr,
-		Value:    ips,
-		ExpireAt: time.Now().Add(r.config.TTL),
+func (r *Resolver) resolve(ctx context.Context, key string, lookup func(context.Context) (any, error)) (any, error) {
+	now := r.config.now()
+	e, ok := r.cacheGet(key)
+	switch {
+	case !ok:
+		r.misses.Add(1)
+	case e.err != nil:
+		r.negative.Add(1)
+		return nil, e.err
+	case now.Before(e.expireAt):
+		r.hits.Add(1)
+		if !now.Before(e.refreshAt) {
+			r.refresh(ctx, key, lookup)
+		}
+		return e.value, nil
+	default:
+		r.stale.Add(1)
+		r.refresh(ctx, key, lookup)
+		return e.value, nil
+	}
+
+	ch := r.lookups.DoChan(key, func() (any, error) {
+		return r.lookup(ctx, key, lookup)
 	})
-	return ips, nil
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		return res.Val, res.Err
+	}
+}
+
+// refresh looks up the key in the background, unless it is already being looked up.
+func (r *Resolver) refresh(ctx context.Context, key string, lookup func(context.Context) (any, error)) {
+	ctx = context.WithoutCancel(ctx)
+	r.lookups.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(ctx, defaultLookupTimeout)
+		defer cancel()
+		v, err := r.lookup(ctx, key, lookup)
+		if err != nil {
+			r.failures.Add(1)
+		}
+		return v, err
+	})
+}
+
+func (r *Resolver) lookup(ctx context.Context, key string, lookup func(context.Context) (any, error)) (any, error) {
+	v, err := lookup(ctx)
+	now := r.config.now()
+	switch {
+	case err == nil:
+		expireAt := now.Add(r.config.TTL)
+		r.cacheSet(key, &entry{
+			value:     v,
+			expireAt:  expireAt,
+			refreshAt: expireAt.Add(-r.config.RefreshBefore),
+			evictAt:   expireAt.Add(r.config.StaleTTL),
+		})
+
+	case isNotFound(err) && r.config.NegativeTTL > 0:
+		expireAt := now.Add(r.config.NegativeTTL)
+		r.cacheSet(key, &entry{
+			err:      err,
+			expireAt: expireAt,
+			evictAt:  expireAt,
+		})
+	}
+	return v, err
 }
 
-func (r *Resolver) cacheSet(item *tinylfu.Item) {
+func isNotFound(err error) bool {
+	dnsErr := &net.DNSError{}
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func (r *Resolver) cacheSet(key string, e *entry) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.cache.Set(item)
+	// the cache does not replace existing items, so a refreshed entry must be removed first
+	r.cache.Del(key)
+	r.cache.Set(&tinylfu.Item{
+		Key:      key,
+		Value:    e,
+		ExpireAt: e.evictAt,
+	})
 }
 
-func (r *Resolver) cacheGet(addr string) (interface{}, bool) {
+func (r *Resolver) cacheGet(key string) (*entry, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	return r.cache.Get(addr)
+	v, ok := r.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	e := v.(*entry)
+	// the cache expires items using the wall clock, so check them against ours as well
+	if !r.config.now().Before(e.evictAt) {
+		return nil, false
+	}
+	return e, true
+}
+
+func (r *Resolver) GaugeName() string {
+	return "dnscache"
+}
+
+// Gauges reports the total number of lookups by result, where result is one of hit, miss,
+// stale or negative, and the number of background refreshes that failed.
+func (r *Resolver) Gauges(context.Context) map[string][]system.TaggedValue {
+	var tags []string
+	if r.config.Name != "" {
+		tags = []string{"resolver:" + r.config.Name}
+	}
+	tagged := func(result string) []string {
+		return append(append([]string{}, tags...), "result:"+result)
+	}
+	return map[string][]system.TaggedValue{
+		"lookups": {
+			{Val: float64(r.hits.Load()), Tags: tagged("hit")},
+			{Val: float64(r.misses.Load()), Tags: tagged("miss")},
+			{Val: float64(r.stale.Load()), Tags: tagged("stale")},
+			{Val: float64(r.negative.Load()), Tags: tagged("negative")},
+		},
+		"refresh_failures": {
+			{Val: float64(r.failures.Load()), Tags: tags},
+		},
+	}
 }
@@ -4,12 +4,16 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"gotest.tools/v3/assert"
 	"gotest.tools/v3/assert/cmp"
+	"gotest.tools/v3/poll"
 
+	"github.com/circleci/ex/system"
 	"github.com/circleci/ex/testing/testcontext"
 )
 
@@ -81,3 +85,187 @@ func TestResolver_Resolve_CheckCached(t *testing.T) {
 		assert.Check(t, cmp.Equal(atomic.LoadInt64(&lookupCount), int64(len(hosts))))
 	})
 }
+
+func TestResolver_Resolve_StaleWhileRevalidate(t *testing.T) {
+	ctx := testcontext.Background()
+
+	clock := &fakeClock{now: time.Now()}
+	var lookupCount atomic.Int64
+	var failing atomic.Bool
+	resolver := New(Config{
+		TTL:      10 * time.Second,
+		StaleTTL: time.Minute,
+		now:      clock.Now,
+		lookupFunc: func(ctx context.Context, r *net.Resolver, host string) ([]net.IP, error) {
+			n := lookupCount.Add(1)
+			if failing.Load() {
+				return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+			}
+			return []net.IP{net.ParseIP(fmt.Sprintf("127.0.0.%d", n))}, nil
+		},
+	})
+	resolve := func(t *testing.T) string {
+		t.Helper()
+		ips, err := resolver.Resolve(ctx, "example.com")
+		assert.Assert(t, err)
+		assert.Assert(t, cmp.Len(ips, 1))
+		return ips[0].String()
+	}
+	waitForRefresh := func(t *testing.T, want string) {
+		t.Helper()
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			e, ok := resolver.cacheGet("example.com")
+			if ok && e.value.([]net.IP)[0].String() == want {
+				return poll.Success()
+			}
+			return poll.Continue("lookups: %d", lookupCount.Load())
+		})
+	}
+	waitForFailures := func(t *testing.T, n int64) {
+		t.Helper()
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			if resolver.failures.Load() == n {
+				return poll.Success()
+			}
+			return poll.Continue("refresh failures: %d", resolver.failures.Load())
+		})
+	}
+
+	assert.Check(t, cmp.Equal(resolve(t), "127.0.0.1"))
+
+	t.Run("refreshed in the background before expiry", func(t *testing.T) {
+		clock.Add(9 * time.Second)
+		assert.Check(t, cmp.Equal(resolve(t), "127.0.0.1"))
+		waitForRefresh(t, "127.0.0.2")
+		assert.Check(t, cmp.Equal(resolve(t), "127.0.0.2"))
+	})
+
+	t.Run("stale served while refreshing", func(t *testing.T) {
+		clock.Add(20 * time.Second)
+		assert.Check(t, cmp.Equal(resolve(t), "127.0.0.2"))
+		waitForRefresh(t, "127.0.0.3")
+		assert.Check(t, cmp.Equal(resolve(t), "127.0.0.3"))
+	})
+
+	t.Run("stale served while the resolver is failing", func(t *testing.T) {
+		failing.Store(true)
+		clock.Add(30 * time.Second)
+		assert.Check(t, cmp.Equal(resolve(t), "127.0.0.3"))
+		waitForFailures(t, 1)
+		assert.Check(t, cmp.Equal(resolve(t), "127.0.0.3"))
+		waitForFailures(t, 2)
+	})
+
+	t.Run("evicted after the stale ttl", func(t *testing.T) {
+		clock.Add(time.Minute)
+		_, err := resolver.Resolve(ctx, "example.com")
+		assert.Check(t, cmp.ErrorContains(err, "server misbehaving"))
+	})
+
+	t.Run("gauges", func(t *testing.T) {
+		assert.Check(t, cmp.DeepEqual(resolver.Gauges(ctx), map[string][]system.TaggedValue{
+			"lookups": {
+				{Val: 3, Tags: []string{"result:hit"}},
+				{Val: 2, Tags: []string{"result:miss"}},
+				{Val: 3, Tags: []string{"result:stale"}},
+				{Val: 0, Tags: []string{"result:negative"}},
+			},
+			"refresh_failures": {
+				{Val: 2},
+			},
+		}))
+	})
+}
+
+func TestResolver_Resolve_NegativeCache(t *testing.T) {
+	ctx := testcontext.Background()
+
+	clock := &fakeClock{now: time.Now()}
+	var lookupCount atomic.Int64
+	resolver := New(Config{
+		NegativeTTL: time.Second,
+		Name:        "test",
+		now:         clock.Now,
+		lookupFunc: func(ctx context.Context, r *net.Resolver, host string) ([]net.IP, error) {
+			lookupCount.Add(1)
+			if host == "flaky.example.com" {
+				return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+			}
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		},
+	})
+
+	for range 5 {
+		_, err := resolver.Resolve(ctx, "missing.example.com")
+		assert.Check(t, cmp.ErrorContains(err, "no such host"))
+	}
+	assert.Check(t, cmp.Equal(lookupCount.Load(), int64(1)))
+
+	clock.Add(time.Second)
+	_, err := resolver.Resolve(ctx, "missing.example.com")
+	assert.Check(t, cmp.ErrorContains(err, "no such host"))
+	assert.Check(t, cmp.Equal(lookupCount.Load(), int64(2)))
+
+	t.Run("temporary errors are not cached", func(t *testing.T) {
+		lookupCount.Store(0)
+		for range 3 {
+			_, err := resolver.Resolve(ctx, "flaky.example.com")
+			assert.Check(t, cmp.ErrorContains(err, "server misbehaving"))
+		}
+		assert.Check(t, cmp.Equal(lookupCount.Load(), int64(3)))
+	})
+
+	t.Run("gauges", func(t *testing.T) {
+		lookups := resolver.Gauges(ctx)["lookups"]
+		assert.Check(t, cmp.Contains(lookups, system.TaggedValue{Val: 4, Tags: []string{"resolver:test", "result:negative"}}))
+		assert.Check(t, cmp.Contains(lookups, system.TaggedValue{Val: 5, Tags: []string{"resolver:test", "result:miss"}}))
+	})
+}
+
+func TestResolver_ResolveSRV(t *testing.T) {
+	ctx := testcontext.Background()
+
+	var lookupCount atomic.Int64
+	resolver := New(Config{
+		lookupSRVFunc: func(ctx context.Context, r *net.Resolver, service, proto, name string) ([]*net.SRV, error) {
+			lookupCount.Add(1)
+			if service == "http" && proto == "tcp" && name == "example.com" {
+				return []*net.SRV{
+					{Target: "a.example.com.", Port: 8080, Priority: 10, Weight: 5},
+					{Target: "b.example.com.", Port: 8081, Priority: 20, Weight: 5},
+				}, nil
+			}
+			return nil, fmt.Errorf("unexpected request: %q %q %q", service, proto, name)
+		},
+	})
+
+	for range 5 {
+		srvs, err := resolver.ResolveSRV(ctx, "http", "tcp", "example.com")
+		assert.Assert(t, err)
+		assert.Check(t, cmp.DeepEqual(srvs, []*net.SRV{
+			{Target: "a.example.com.", Port: 8080, Priority: 10, Weight: 5},
+			{Target: "b.example.com.", Port: 8081, Priority: 20, Weight: 5},
+		}))
+	}
+	assert.Check(t, cmp.Equal(lookupCount.Load(), int64(1)))
+
+	_, err := resolver.ResolveSRV(ctx, "grpc", "tcp", "example.com")
+	assert.Check(t, cmp.ErrorContains(err, "unexpected request"))
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -7,8 +7,16 @@ import (
 	"time"
 )
 
+// fallbackDelay is how long to wait for a connection attempt before starting the next one in
+// parallel, as recommended by RFC 8305.
+const fallbackDelay = 250 * time.Millisecond
+
 type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
 
+// DialContext returns a dial func that resolves hosts using the resolver, and connects to the
+// resolved addresses happy eyeballs style (RFC 8305). The addresses are tried in a random order
+// alternating between IPv6 and IPv4, starting the next attempt if the previous one has not
+// connected within 250ms, or as soon as it fails. The first connection made is used.
 func DialContext(resolver *Resolver, baseDial DialFunc) DialFunc {
 	if baseDial == nil {
 		baseDial = (&net.Dialer{
@@ -26,20 +34,101 @@ func DialContext(resolver *Resolver, baseDial DialFunc) DialFunc {
 		if err != nil {
 			return nil, err
 		}
+		if len(ips) == 0 {
+			return nil, &net.AddrError{Err: "no addresses found", Addr: h}
+		}
+
+		return dialParallel(ctx, baseDial, network, p, interleave(ips), fallbackDelay)
+	}
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
 
-		var firstErr error
-		for _, randomIndex := range randPerm(len(ips)) {
-			conn, err := baseDial(ctx, network, net.JoinHostPort(ips[randomIndex].String(), p))
-			if err == nil {
-				return conn, nil
+func dialParallel(ctx context.Context, dial DialFunc, network, port string, ips []net.IP,
+	delay time.Duration) (net.Conn, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(ips))
+	next, inFlight := 0, 0
+	start := func() {
+		addr := net.JoinHostPort(ips[next].String(), port)
+		next++
+		inFlight++
+		go func() {
+			conn, err := dial(ctx, network, addr)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var firstErr error
+	start()
+	for inFlight > 0 {
+		select {
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+				timer.Reset(delay)
+			}
+		case res := <-results:
+			inFlight--
+			if res.err == nil {
+				go closeLosers(results, inFlight)
+				return res.conn, nil
 			}
 			if firstErr == nil {
-				firstErr = err
+				firstErr = res.err
+			}
+			// a failed attempt starts the next one straight away
+			if next < len(ips) {
+				start()
+				timer.Reset(delay)
 			}
 		}
+	}
+
+	return nil, firstErr
+}
 
-		return nil, firstErr
+// closeLosers closes any connections made by attempts still in flight when another won.
+func closeLosers(results <-chan dialResult, n int) {
+	for range n {
+		if res := <-results; res.conn != nil {
+			_ = res.conn.Close()
+		}
+	}
+}
+
+// interleave shuffles the addresses, and then alternates between IPv6 and IPv4 addresses,
+// starting with IPv6.
+func interleave(ips []net.IP) []net.IP {
+	var v6, v4 []net.IP
+	for _, randomIndex := range randPerm(len(ips)) {
+		ip := ips[randomIndex]
+		if ip.To4() == nil {
+			v6 = append(v6, ip)
+		} else {
+			v4 = append(v4, ip)
+		}
+	}
+
+	out := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			out = append(out, v6[i])
+		}
+		if i < len(v4) {
+			out = append(out, v4[i])
+		}
 	}
+	return out
 }
 
 var randPerm = func(n int) []int {
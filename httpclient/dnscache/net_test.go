@@ -2,19 +2,23 @@ package dnscache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"gotest.tools/v3/assert"
 	"gotest.tools/v3/assert/cmp"
 
 	"github.com/circleci/ex/closer"
+	"github.com/circleci/ex/testing/testcontext"
 )
 
 func TestDial(t *testing.T) {
@@ -67,3 +71,75 @@ func TestDial(t *testing.T) {
 		assert.Check(t, cmp.Equal(atomic.LoadInt64(&lookupCount), int64(1)))
 	})
 }
+
+func TestDial_HappyEyeballs(t *testing.T) {
+	ctx := testcontext.Background()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Assert(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	assert.Assert(t, err)
+
+	origRandPerm := randPerm
+	randPerm = func(n int) []int {
+		perm := make([]int, n)
+		for i := range perm {
+			perm[i] = i
+		}
+		return perm
+	}
+	t.Cleanup(func() { randPerm = origRandPerm })
+
+	resolver := New(Config{
+		lookupFunc: func(ctx context.Context, r *net.Resolver, host string) ([]net.IP, error) {
+			return []net.IP{
+				net.ParseIP("10.0.0.1"),
+				net.ParseIP("::1"),
+				net.ParseIP("127.0.0.1"),
+			}, nil
+		},
+	})
+
+	var mu sync.Mutex
+	var dialed []string
+	dial := DialContext(resolver, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, addr)
+		mu.Unlock()
+		host, _, _ := net.SplitHostPort(addr)
+		switch host {
+		case "::1":
+			return nil, errors.New("connection refused")
+		case "127.0.0.1":
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		}
+		// black hole 10.0.0.1
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	start := time.Now()
+	conn, err := dial(ctx, "tcp", net.JoinHostPort("example.com", port))
+	assert.Assert(t, err)
+	assert.Check(t, conn.Close())
+
+	// the refused IPv6 address moves straight on, so only one fallback delay is waited for
+	assert.Check(t, time.Since(start) < 2*fallbackDelay)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Check(t, cmp.DeepEqual(dialed, []string{
+		net.JoinHostPort("::1", port),
+		net.JoinHostPort("10.0.0.1", port),
+		net.JoinHostPort("127.0.0.1", port),
+	}))
+}
@@ -0,0 +1,172 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/cenkalti/backoff/v5"
+)
+
+// maxErrorBodySize is the most of an error response body that is read for the error decoder.
+const maxErrorBodySize = 1 << 20
+
+// ErrorDecoder decodes the body of a non 2XX response into an error, which is attached to the
+// returned HTTPError and can be found with errors.As. It should return nil if the body is not
+// one it understands, for instance if the content type is not as expected.
+//
+// If the decoded error has a Retryable() bool method, it decides whether the call is retried,
+// rather than the status code.
+type ErrorDecoder func(res *http.Response) error
+
+// ErrorBody sets the error decoder for the request, overriding any set on the client.
+func ErrorBody(decoder ErrorDecoder) func(*Request) {
+	return func(r *Request) {
+		r.errorDecoder = decoder
+	}
+}
+
+// JSONErrorDecoder decodes JSON error bodies into a new E, where *E is an error.
+//
+// Example:
+//
+//	type apiError struct {
+//	  Message   string `json:"message"`
+//	  Transient bool   `json:"transient"`
+//	}
+//
+//	func (e *apiError) Error() string   { return e.Message }
+//	func (e *apiError) Retryable() bool { return e.Transient }
+//
+//	client := httpclient.New(httpclient.Config{
+//	  ErrorDecoder: httpclient.JSONErrorDecoder[apiError](),
+//	})
+//	...
+//	err := client.Call(ctx, req)
+//	apiErr := &apiError{}
+//	if errors.As(err, &apiErr) {
+//	  ...
+//	}
+func JSONErrorDecoder[E any, PE interface {
+	*E
+	error
+}]() ErrorDecoder {
+	return func(res *http.Response) error {
+		if !isJSON(res.Header.Get("Content-Type")) {
+			return nil
+		}
+		e := PE(new(E))
+		if err := json.NewDecoder(res.Body).Decode(e); err != nil {
+			return nil
+		}
+		return e
+	}
+}
+
+// Problem is an RFC 7807 problem details error body.
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Extensions are any other members of the problem.
+	Extensions map[string]any `json:"-"`
+}
+
+func (p *Problem) Error() string {
+	msg := p.Title
+	if msg == "" {
+		msg = p.Type
+	}
+	if p.Detail != "" {
+		msg += ": " + p.Detail
+	}
+	return "problem: " + msg
+}
+
+func (p *Problem) UnmarshalJSON(b []byte) error {
+	type problem Problem
+	if err := json.Unmarshal(b, (*problem)(p)); err != nil {
+		return err
+	}
+	ext := map[string]any{}
+	if err := json.Unmarshal(b, &ext); err != nil {
+		return err
+	}
+	for _, k := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(ext, k)
+	}
+	if len(ext) > 0 {
+		p.Extensions = ext
+	}
+	return nil
+}
+
+// ProblemDecoder decodes application/problem+json response bodies into a *Problem.
+func ProblemDecoder() ErrorDecoder {
+	return func(res *http.Response) error {
+		mt, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		if mt != "application/problem+json" {
+			return nil
+		}
+		p := &Problem{}
+		if err := json.NewDecoder(res.Body).Decode(p); err != nil {
+			return nil
+		}
+		return p
+	}
+}
+
+func isJSON(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// decodeErrorBody attaches the decoded error body to the HTTPError in err, and returns the error
+// to retry with. The response body is buffered so that it can still be read by any failure decoder.
+func decodeErrorBody(decoder ErrorDecoder, err error, res *http.Response) error {
+	httpErr := &HTTPError{}
+	if decoder == nil || !errors.As(err, &httpErr) {
+		return err
+	}
+	// a failed read leaves a partial body, which the decoder is expected to reject
+	b, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	res.Body = readCloser{
+		Reader: io.MultiReader(bytes.NewReader(b), res.Body),
+		Closer: res.Body,
+	}
+
+	body := decoder(&http.Response{
+		Status:     res.Status,
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       io.NopCloser(bytes.NewReader(b)),
+		Request:    res.Request,
+	})
+	if body == nil {
+		return err
+	}
+	httpErr.body = body
+
+	var retryable interface{ Retryable() bool }
+	if !errors.As(body, &retryable) {
+		return err
+	}
+	var permanent *backoff.PermanentError
+	isPermanent := errors.As(err, &permanent)
+	switch {
+	case retryable.Retryable() && isPermanent:
+		return permanent.Unwrap()
+	case !retryable.Retryable() && !isPermanent:
+		return backoff.Permanent(err)
+	}
+	return err
+}
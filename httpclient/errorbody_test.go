@@ -0,0 +1,114 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+type apiError struct {
+	Message   string `json:"message"`
+	Transient bool   `json:"transient"`
+}
+
+func (e *apiError) Error() string   { return e.Message }
+func (e *apiError) Retryable() bool { return e.Transient }
+
+func TestClient_Call_ErrorDecoder(t *testing.T) {
+	ctx := testcontext.Background()
+
+	var calls atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("/problem", func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, `{"type":"https://example.com/out-of-credit","title":"Out of credit",`+
+			`"status":403,"detail":"Your balance is 30","balance":30}`)
+	})
+	mux.HandleFunc("/permanent", func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = io.WriteString(w, `{"message":"bad config","transient":false}`)
+	})
+	mux.HandleFunc("/transient", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = io.WriteString(w, `{"message":"locked","transient":true}`)
+		}
+	})
+	mux.HandleFunc("/text", func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, "not json")
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := New(Config{
+		BaseURL:      server.URL,
+		Timeout:      5 * time.Second,
+		ErrorDecoder: JSONErrorDecoder[apiError](),
+	})
+
+	t.Run("problem", func(t *testing.T) {
+		calls.Store(0)
+		err := client.Call(ctx, NewRequest("GET", "/problem", ErrorBody(ProblemDecoder())))
+		assert.Check(t, HasStatusCode(err, http.StatusForbidden))
+		assert.Check(t, cmp.ErrorContains(err, "problem: Out of credit: Your balance is 30"))
+
+		problem := &Problem{}
+		assert.Assert(t, errors.As(err, &problem))
+		assert.Check(t, cmp.DeepEqual(problem, &Problem{
+			Type:       "https://example.com/out-of-credit",
+			Title:      "Out of credit",
+			Status:     http.StatusForbidden,
+			Detail:     "Your balance is 30",
+			Extensions: map[string]any{"balance": float64(30)},
+		}))
+	})
+
+	t.Run("not retryable server error", func(t *testing.T) {
+		calls.Store(0)
+		err := client.Call(ctx, NewRequest("GET", "/permanent"))
+		assert.Check(t, HasStatusCode(err, http.StatusInternalServerError))
+		apiErr := &apiError{}
+		assert.Assert(t, errors.As(err, &apiErr))
+		assert.Check(t, cmp.Equal(apiErr.Message, "bad config"))
+		assert.Check(t, cmp.Equal(calls.Load(), int64(1)))
+	})
+
+	t.Run("retryable client error", func(t *testing.T) {
+		calls.Store(0)
+		err := client.Call(ctx, NewRequest("GET", "/transient"))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+	})
+
+	t.Run("failure decoders still see the body", func(t *testing.T) {
+		var body string
+		err := client.Call(ctx, NewRequest("GET", "/permanent",
+			Decoder(http.StatusInternalServerError, NewStringDecoder(&body)),
+		))
+		assert.Check(t, HasStatusCode(err, http.StatusInternalServerError))
+		assert.Check(t, cmp.Equal(body, `{"message":"bad config","transient":false}`))
+	})
+
+	t.Run("undecodable bodies are not attached", func(t *testing.T) {
+		err := client.Call(ctx, NewRequest("GET", "/text"))
+		assert.Check(t, cmp.Error(err, "the response from GET /text was 400 (Bad Request) (1 attempts)"))
+		apiErr := &apiError{}
+		assert.Check(t, !errors.As(err, &apiErr))
+	})
+}
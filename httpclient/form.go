@@ -0,0 +1,120 @@
+package httpclient
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// FormBody sets the request body to the URL encoded form values.
+//
+// Example:
+//
+//	req := httpclient.NewRequest("POST", "/oauth/token",
+//	  httpclient.FormBody(url.Values{"grant_type": {"client_credentials"}}),
+//	)
+func FormBody(values url.Values) func(*Request) {
+	return func(r *Request) {
+		r.rawBody = []byte(values.Encode())
+		r.headers["Content-Type"] = formContentType
+	}
+}
+
+// MultipartPart is a field or file in a multipart form body, see FormField and FormFile.
+type MultipartPart struct {
+	name     string
+	filename string
+	value    string
+	open     func() (io.Reader, error)
+}
+
+// FormField is a multipart form field.
+func FormField(name, value string) MultipartPart {
+	return MultipartPart{name: name, value: value}
+}
+
+// FormFile is a multipart form file. The open func is called on every attempt, and must return
+// a reader positioned at the start of the file each time. If the reader is an io.Closer it
+// will be closed once the file has been sent.
+func FormFile(name, filename string, open func() (io.Reader, error)) MultipartPart {
+	return MultipartPart{name: name, filename: filename, open: open}
+}
+
+// MultipartBody sets a streamed multipart/form-data request body. The body is written as it is
+// sent, rather than being buffered, so files of any size can be uploaded. The body is rebuilt
+// for every attempt, and as the length is not known it is sent using chunked encoding. Auth
+// providers that sign the body, such as HMAC and SigV4, read it through an extra time to hash it.
+//
+// Example:
+//
+//	req := httpclient.NewRequest("POST", "/artifacts",
+//	  httpclient.MultipartBody(
+//	    httpclient.FormField("name", name),
+//	    httpclient.FormFile("file", "build.tar.gz", func() (io.Reader, error) {
+//	      return os.Open(path)
+//	    }),
+//	  ),
+//	)
+func MultipartBody(parts ...MultipartPart) func(*Request) {
+	// the boundary is fixed so that the content type is the same for every attempt
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	return func(r *Request) {
+		r.headers["Content-Type"] = "multipart/form-data; boundary=" + boundary
+		r.bodyFn = func() (io.Reader, error) {
+			// open the files up front, so that failing to open one is not retried
+			files := make([]io.Reader, len(parts))
+			for i, p := range parts {
+				if p.open == nil {
+					continue
+				}
+				rd, err := p.open()
+				if err != nil {
+					closeAll(files)
+					return nil, fmt.Errorf("multipart file %q: %w", p.name, err)
+				}
+				files[i] = rd
+			}
+			pr, pw := io.Pipe()
+			go func() {
+				err := writeMultipart(pw, boundary, parts, files)
+				closeAll(files)
+				_ = pw.CloseWithError(err)
+			}()
+			return pr, nil
+		}
+	}
+}
+
+func writeMultipart(w io.Writer, boundary string, parts []MultipartPart, files []io.Reader) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for i, p := range parts {
+		if files[i] == nil {
+			if err := mw.WriteField(p.name, p.value); err != nil {
+				return err
+			}
+			continue
+		}
+		fw, err := mw.CreateFormFile(p.name, p.filename)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(fw, files[i]); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func closeAll(files []io.Reader) {
+	for _, f := range files {
+		if c, ok := f.(io.Closer); ok {
+			_ = c.Close()
+		}
+	}
+}
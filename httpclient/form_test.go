@@ -0,0 +1,156 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+	"gotest.tools/v3/poll"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestClient_Call_FormBody(t *testing.T) {
+	ctx := testcontext.Background()
+
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Check(t, cmp.Equal(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded"))
+		assert.Check(t, cmp.Equal(r.ContentLength, int64(len("colour=red&name=apple"))))
+		assert.Check(t, r.ParseForm())
+		assert.Check(t, cmp.Equal(r.PostForm.Get("name"), "apple"))
+		assert.Check(t, cmp.Equal(r.PostForm.Get("colour"), "red"))
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := New(Config{
+		BaseURL: server.URL,
+		Timeout: 5 * time.Second,
+	})
+	err := client.Call(ctx, NewRequest("POST", "/fruit",
+		FormBody(url.Values{"name": {"apple"}, "colour": {"red"}}),
+	))
+	assert.Check(t, err)
+	assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+}
+
+func TestClient_Call_MultipartBody(t *testing.T) {
+	ctx := testcontext.Background()
+
+	type upload struct {
+		Name, Colour, Filename, File string
+	}
+	var mu sync.Mutex
+	var uploads []upload
+	var contentTypes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Check(t, r.ParseMultipartForm(1<<20))
+		u := upload{
+			Name:   r.FormValue("name"),
+			Colour: r.FormValue("colour"),
+		}
+		f, h, err := r.FormFile("file")
+		if assert.Check(t, err) {
+			b, err := io.ReadAll(f)
+			assert.Check(t, err)
+			u.Filename, u.File = h.Filename, string(b)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		uploads = append(uploads, u)
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		if len(uploads) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := New(Config{
+		BaseURL: server.URL,
+		Timeout: 5 * time.Second,
+	})
+
+	t.Run("rebuilt on retry", func(t *testing.T) {
+		opens := 0
+		closer := &closeCounter{}
+		err := client.Call(ctx, NewRequest("POST", "/upload",
+			MultipartBody(
+				FormField("name", "apple"),
+				FormFile("file", "apple.txt", func() (io.Reader, error) {
+					opens++
+					closer.Reader = strings.NewReader("a crunchy apple")
+					return closer, nil
+				}),
+				FormField("colour", "red"),
+			),
+		))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(opens, 2))
+		assert.Check(t, cmp.Equal(closer.closed.Load(), int64(2)))
+		want := upload{Name: "apple", Colour: "red", Filename: "apple.txt", File: "a crunchy apple"}
+		assert.Check(t, cmp.DeepEqual(uploads, []upload{want, want}))
+		assert.Assert(t, cmp.Len(contentTypes, 2))
+		assert.Check(t, strings.HasPrefix(contentTypes[0], "multipart/form-data; boundary="))
+		assert.Check(t, cmp.Equal(contentTypes[0], contentTypes[1]))
+	})
+
+	t.Run("open errors are not retried", func(t *testing.T) {
+		opens := 0
+		err := client.Call(ctx, NewRequest("POST", "/upload",
+			MultipartBody(
+				FormFile("file", "apple.txt", func() (io.Reader, error) {
+					opens++
+					return nil, errors.New("no such file")
+				}),
+			),
+		))
+		assert.Check(t, cmp.ErrorContains(err, `multipart file "file": no such file`))
+		assert.Check(t, cmp.Equal(opens, 1))
+	})
+
+	t.Run("files are closed if the attempt gives up", func(t *testing.T) {
+		client := New(Config{
+			BaseURL: server.URL,
+			Timeout: 5 * time.Second,
+			Auth:    &fakeAuth{err: errors.New("no credentials")},
+		})
+		closer := &closeCounter{Reader: strings.NewReader("a crunchy apple")}
+		err := client.Call(ctx, NewRequest("POST", "/upload",
+			MultipartBody(
+				FormFile("file", "apple.txt", func() (io.Reader, error) {
+					return closer, nil
+				}),
+			),
+		))
+		assert.Check(t, cmp.ErrorContains(err, "no credentials"))
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			if closer.closed.Load() != 1 {
+				return poll.Continue("file not closed")
+			}
+			return poll.Success()
+		})
+	})
+}
+
+type closeCounter struct {
+	io.Reader
+	closed atomic.Int64
+}
+
+func (c *closeCounter) Close() error {
+	c.closed.Add(1)
+	return nil
+}
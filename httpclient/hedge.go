@@ -0,0 +1,210 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+
+	"github.com/circleci/ex/o11y"
+)
+
+// defaultHedgeDelay is the hedge delay when none is configured.
+const defaultHedgeDelay = 100 * time.Millisecond
+
+// errHedgeLost is returned by an attempt that succeeded after another hedged attempt had already won.
+var errHedgeLost = errors.New("hedged attempt lost")
+
+// HedgeConfig configures request hedging. If an attempt has not completed within the hedge delay
+// another attempt is made in parallel, and the first to succeed is used.
+type HedgeConfig struct {
+	// Delay is how long to wait before sending each hedged attempt, the default is 100ms. It is
+	// also used when Percentile is set but there have not been enough requests to the route to
+	// calculate it.
+	Delay time.Duration
+	// Percentile if set (e.g. 0.95) uses that percentile of the recent latencies of the route as
+	// the hedge delay.
+	Percentile float64
+	// MaxAttempts is the maximum number of attempts in flight at once, the default is 2.
+	MaxAttempts int
+}
+
+// Hedge enables request hedging, which is only allowed for idempotent requests. Each attempt,
+// including any retries, may be hedged. The request decoders are only called for the winning
+// attempt.
+//
+// Example:
+//
+//	err := client.Call(ctx, httpclient.NewRequest("GET", "/api/fruit",
+//	  httpclient.Hedge(httpclient.HedgeConfig{Percentile: 0.95, Delay: 50 * time.Millisecond}),
+//	  httpclient.JSONDecoder(&fruit),
+//	))
+func Hedge(cfg HedgeConfig) func(*Request) {
+	return func(r *Request) {
+		if cfg.MaxAttempts == 0 {
+			cfg.MaxAttempts = 2
+		}
+		// without a delay every attempt would be sent at once
+		if cfg.Delay <= 0 {
+			cfg.Delay = defaultHedgeDelay
+		}
+		r.hedge = &cfg
+	}
+}
+
+// Idempotent marks the request as safe to send more than once at the same time. Requests using
+// GET, HEAD, OPTIONS, TRACE, PUT or DELETE are always treated as idempotent.
+func Idempotent() func(*Request) {
+	return func(r *Request) {
+		r.idempotent = true
+	}
+}
+
+func (r Request) isIdempotent() bool {
+	switch r.method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.idempotent
+}
+
+const (
+	latencySamples    = 128
+	latencyMinSamples = 20
+)
+
+// latencies records the recent successful attempt durations for each route.
+type latencies struct {
+	mu     sync.Mutex
+	routes map[string]*latencyRing
+}
+
+type latencyRing struct {
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencies) record(route string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.routes == nil {
+		l.routes = map[string]*latencyRing{}
+	}
+	ring, ok := l.routes[route]
+	if !ok {
+		ring = &latencyRing{}
+		l.routes[route] = ring
+	}
+	if len(ring.samples) < latencySamples {
+		ring.samples = append(ring.samples, d)
+		return
+	}
+	ring.samples[ring.next] = d
+	ring.next = (ring.next + 1) % latencySamples
+}
+
+// percentile returns the p percentile of the recorded latencies for the route, or false if there
+// are not enough samples.
+func (l *latencies) percentile(route string, p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	ring, ok := l.routes[route]
+	var samples []time.Duration
+	if ok {
+		samples = slices.Clone(ring.samples)
+	}
+	l.mu.Unlock()
+
+	if len(samples) < latencyMinSamples {
+		return 0, false
+	}
+	slices.Sort(samples)
+	i := int(math.Ceil(p*float64(len(samples)))) - 1
+	return samples[min(max(i, 0), len(samples)-1)], true
+}
+
+func (c *Client) hedgeDelay(r Request) time.Duration {
+	if r.hedge.Percentile > 0 {
+		if d, ok := c.latencies.percentile(r.route, r.hedge.Percentile); ok {
+			return d
+		}
+	}
+	return r.hedge.Delay
+}
+
+// hedged makes the attempt, sending another attempt in parallel each time the hedge delay passes
+// without a response, up to MaxAttempts. The first attempt to succeed wins and the others are
+// cancelled, and left to finish without decoding their responses. If every attempt fails the
+// error from the first attempt to fail with a permanent error, or else the last to fail, is
+// returned, once the cancelled attempts have finished so none of them can still be decoding.
+func (c *Client) hedged(ctx context.Context, r Request, attempt func(ctx context.Context, hedge int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		hedge int
+		err   error
+	}
+	results := make(chan result, r.hedge.MaxAttempts)
+	launched := 0
+	launch := func() {
+		launched++
+		hedge := launched
+		go func() {
+			results <- result{hedge: hedge, err: attempt(ctx, hedge)}
+		}()
+	}
+
+	delay := c.hedgeDelay(r)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	launch()
+	inFlight := 1
+	var lastErr error
+	for inFlight > 0 {
+		select {
+		case <-timer.C:
+			if launched < r.hedge.MaxAttempts {
+				launch()
+				inFlight++
+				timer.Reset(delay)
+			}
+		case res := <-results:
+			inFlight--
+			if res.err == nil {
+				c.recordHedgeWinner(ctx, r, res.hedge)
+				return nil
+			}
+			lastErr = res.err
+			var permanent *backoff.PermanentError
+			if errors.As(res.err, &permanent) {
+				cancel()
+				for ; inFlight > 0; inFlight-- {
+					<-results
+				}
+				return res.err
+			}
+		}
+	}
+	return lastErr
+}
+
+func (c *Client) recordHedgeWinner(ctx context.Context, r Request, hedge int) {
+	m := o11y.FromContext(ctx).MetricsProvider()
+	if m == nil {
+		return
+	}
+	_ = m.Count("httpclient.hedge", 1, []string{
+		"http.client_name:" + c.name,
+		"http.route:" + r.route,
+		"http.method:" + r.method,
+		"http.hedge_winner:" + strconv.Itoa(hedge),
+	}, 1)
+}
@@ -0,0 +1,185 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/o11y"
+	"github.com/circleci/ex/o11y/otel"
+	"github.com/circleci/ex/testing/fakemetrics"
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestClient_Call_Hedge(t *testing.T) {
+	m := &fakemetrics.Provider{}
+	p, err := otel.New(otel.Config{Metrics: m})
+	assert.NilError(t, err)
+	ctx := o11y.WithProvider(context.Background(), p)
+
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := calls.Add(1)
+		if call == 1 {
+			// the first attempt is slow to answer
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+		}
+		_, _ = io.WriteString(w, strconv.FormatInt(call, 10))
+	}))
+	t.Cleanup(server.Close)
+
+	client := New(Config{
+		Name:    "hedge-test",
+		BaseURL: server.URL,
+		Timeout: 5 * time.Second,
+	})
+
+	t.Run("second attempt wins", func(t *testing.T) {
+		start := time.Now()
+		decodes := 0
+		var body string
+		err := client.Call(ctx, NewRequest("GET", "/slow",
+			Hedge(HedgeConfig{Delay: 50 * time.Millisecond}),
+			StringDecoder(&body),
+			ResponseHeader(func(http.Header) { decodes++ }),
+		))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(body, "2"))
+		assert.Check(t, cmp.Equal(decodes, 1))
+		assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+		assert.Check(t, time.Since(start) < time.Second)
+
+		assert.Check(t, slices.ContainsFunc(m.Calls(), func(c fakemetrics.MetricCall) bool {
+			return c.Name == "httpclient.hedge" && slices.Contains(c.Tags, "http.hedge_winner:2")
+		}), m.Calls())
+	})
+
+	t.Run("fast first attempt is not hedged", func(t *testing.T) {
+		calls.Store(1)
+		err := client.Call(ctx, NewRequest("GET", "/fast",
+			Hedge(HedgeConfig{Delay: time.Second}),
+		))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+	})
+
+	t.Run("zero delay does not hedge at once", func(t *testing.T) {
+		calls.Store(1)
+		err := client.Call(ctx, NewRequest("GET", "/fast",
+			Hedge(HedgeConfig{}),
+		))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+	})
+
+	t.Run("non idempotent requests are rejected", func(t *testing.T) {
+		calls.Store(1)
+		err := client.Call(ctx, NewRequest("POST", "/create",
+			Hedge(HedgeConfig{Delay: 50 * time.Millisecond}),
+		))
+		assert.Check(t, cmp.Error(err, "cannot hedge a non idempotent POST request"))
+		assert.Check(t, cmp.Equal(calls.Load(), int64(1)))
+	})
+
+	t.Run("idempotent requests are allowed", func(t *testing.T) {
+		calls.Store(1)
+		err := client.Call(ctx, NewRequest("POST", "/create",
+			Idempotent(),
+			Hedge(HedgeConfig{Delay: 50 * time.Millisecond}),
+		))
+		assert.Check(t, err)
+	})
+}
+
+func TestClient_Call_HedgeLoserDoesNotDecode(t *testing.T) {
+	ctx := testcontext.Background()
+
+	streaming := make(chan struct{})
+	failed := make(chan struct{})
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// the first attempt fails while the second is streaming its response
+			<-streaming
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, "failed")
+			w.(http.Flusher).Flush()
+			close(failed)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(server.Close)
+
+	client := New(Config{
+		BaseURL: server.URL,
+		Timeout: 5 * time.Second,
+	})
+
+	var failDecodes atomic.Int64
+	var body []byte
+	err := client.Call(ctx, NewRequest("GET", "/",
+		Hedge(HedgeConfig{Delay: 20 * time.Millisecond}),
+		Decoder(http.StatusInternalServerError, func(io.Reader) error {
+			failDecodes.Add(1)
+			return nil
+		}),
+		StreamResponse(func(r io.Reader, _ http.Header) (err error) {
+			close(streaming)
+			<-failed
+			// give the losing attempt time to handle its response, it must not wait for this
+			time.Sleep(50 * time.Millisecond)
+			body, err = io.ReadAll(r)
+			return err
+		}),
+	))
+	assert.Check(t, err)
+	assert.Check(t, cmp.Equal(string(body), "ok"))
+
+	// the losing attempt may still be running after the call has returned
+	time.Sleep(100 * time.Millisecond)
+	assert.Check(t, cmp.Equal(failDecodes.Load(), int64(0)))
+}
+
+func TestClient_HedgeDelay(t *testing.T) {
+	client := New(Config{BaseURL: "http://localhost"})
+	req := NewRequest("GET", "/a", Hedge(HedgeConfig{Delay: time.Second, Percentile: 0.9}))
+
+	t.Run("delay is used without enough samples", func(t *testing.T) {
+		for i := 1; i < latencyMinSamples; i++ {
+			client.latencies.record("/a", time.Duration(i)*time.Millisecond)
+		}
+		assert.Check(t, cmp.Equal(client.hedgeDelay(req), time.Second))
+	})
+
+	t.Run("percentile is used with enough samples", func(t *testing.T) {
+		for i := latencyMinSamples; i <= 100; i++ {
+			client.latencies.record("/a", time.Duration(i)*time.Millisecond)
+		}
+		assert.Check(t, cmp.Equal(client.hedgeDelay(req), 90*time.Millisecond))
+	})
+
+	t.Run("only recent samples are used", func(t *testing.T) {
+		for i := 0; i < latencySamples; i++ {
+			client.latencies.record("/a", time.Millisecond)
+		}
+		assert.Check(t, cmp.Equal(client.hedgeDelay(req), time.Millisecond))
+	})
+
+	t.Run("delay has a default", func(t *testing.T) {
+		req := NewRequest("GET", "/b", Hedge(HedgeConfig{}))
+		assert.Check(t, cmp.Equal(client.hedgeDelay(req), defaultHedgeDelay))
+	})
+}
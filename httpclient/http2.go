@@ -0,0 +1,66 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// HTTP2Config configures HTTP/2 for the client transport.
+type HTTP2Config struct {
+	// H2C uses unencrypted HTTP/2 with prior knowledge for http:// URLs, for plaintext services
+	// inside a cluster. The server must support h2c, since requests are not upgraded from HTTP/1.1.
+	// HTTPS URLs still negotiate HTTP/2 with ALPN.
+	H2C bool
+	// Disabled turns HTTP/2 off, so that only HTTP/1.1 is used.
+	Disabled bool
+	// ReadIdleTimeout if set sends a ping health check on a connection that has not received a
+	// frame within the timeout. Connections that fail the health check are closed, rather than
+	// requests hanging on a connection that has silently died.
+	ReadIdleTimeout time.Duration
+	// PingTimeout is how long to wait for a response to a health check ping before closing the
+	// connection, the default is 15 seconds.
+	PingTimeout time.Duration
+	// WriteByteTimeout if set closes a connection when no data can be written to it for the timeout.
+	WriteByteTimeout time.Duration
+}
+
+func (c *HTTP2Config) apply(t *http.Transport) {
+	protocols := &http.Protocols{}
+	protocols.SetHTTP1(true)
+	switch {
+	case c.Disabled:
+		t.ForceAttemptHTTP2 = false
+		t.HTTP2 = nil
+		t.Protocols = protocols
+		return
+	case c.H2C:
+		// without HTTP/1.1 the transport uses h2c for http:// URLs
+		protocols.SetHTTP1(false)
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	protocols.SetHTTP2(true)
+	t.Protocols = protocols
+	t.ForceAttemptHTTP2 = true
+	t.HTTP2 = &http.HTTP2Config{
+		SendPingTimeout:  c.ReadIdleTimeout,
+		PingTimeout:      c.PingTimeout,
+		WriteByteTimeout: c.WriteByteTimeout,
+	}
+}
+
+// connTrace records whether the connection used for an attempt was reused from the pool.
+type connTrace struct {
+	reused atomic.Bool
+}
+
+func withConnTrace(ctx context.Context) (context.Context, *connTrace) {
+	ct := &connTrace{}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			ct.reused.Store(info.Reused)
+		},
+	}), ct
+}
@@ -0,0 +1,71 @@
+package httpclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestClient_Call_HTTP2(t *testing.T) {
+	ctx := testcontext.Background()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	})
+
+	t.Run("h2c", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(handler)
+		server.Config.Protocols = &http.Protocols{}
+		server.Config.Protocols.SetHTTP1(true)
+		server.Config.Protocols.SetUnencryptedHTTP2(true)
+		server.Start()
+		t.Cleanup(server.Close)
+
+		client := New(Config{
+			BaseURL: server.URL,
+			Timeout: 5 * time.Second,
+			HTTP2: &HTTP2Config{
+				H2C:             true,
+				ReadIdleTimeout: time.Second,
+			},
+		})
+		var proto string
+		err := client.Call(ctx, NewRequest("GET", "/", StringDecoder(&proto)))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(proto, "HTTP/2.0"))
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(handler)
+		server.EnableHTTP2 = true
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		newClient := func(cfg *HTTP2Config) *Client {
+			return New(Config{
+				BaseURL: server.URL,
+				Timeout: 5 * time.Second,
+				HTTP2:   cfg,
+				TransportModifier: func(transport *http.Transport) {
+					transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+					transport.TLSClientConfig.NextProtos = nil
+				},
+			})
+		}
+
+		var proto string
+		err := newClient(&HTTP2Config{}).Call(ctx, NewRequest("GET", "/", StringDecoder(&proto)))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(proto, "HTTP/2.0"))
+
+		err = newClient(&HTTP2Config{Disabled: true}).Call(ctx, NewRequest("GET", "/", StringDecoder(&proto)))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(proto, "HTTP/1.1"))
+	})
+}
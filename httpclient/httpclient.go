@@ -1,6 +1,7 @@
 // Package httpclient provides an HTTP client instrumented with the ex/o11y package, it
 // includes resiliency behaviour such as configurable timeouts, retries, authentication
-// and connection pooling, with support for backing off when a 429 response code is seen.
+// and connection pooling, with support for backing off when a 429 response code is seen,
+// and an optional circuit breaker.
 package httpclient
 
 import (
@@ -10,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/http"
 	"net/url"
@@ -19,6 +21,7 @@ import (
 
 	"github.com/cenkalti/backoff/v5"
 
+	"github.com/circleci/ex/config/secret"
 	"github.com/circleci/ex/o11y"
 )
 
@@ -40,11 +43,16 @@ type Config struct {
 	Name string
 	// BaseURL is the URL and optional path prefix to the server that this is a client of.
 	BaseURL string
+	// Endpoints if set balances calls across a set of base URLs, instead of using the BaseURL.
+	Endpoints *EndpointsConfig
 	// AuthHeader the name of the header that the AuthToken will be set on. If empty then
 	// the AuthToken will be used in a bearer token authorization header
 	AuthHeader string
 	// AuthToken is the token to use for authentication.
 	AuthToken string
+	// Auth if set is called to authenticate every attempt, for credentials that need refreshing
+	// or requests that need signing. It is applied after the AuthToken.
+	Auth AuthProvider
 	// AcceptType if set will be used to set the Accept header.
 	AcceptType string
 	// Timeout is the maximum time any call can take including any retries.
@@ -56,17 +64,34 @@ type Config struct {
 	UserAgent string
 	// Transport allows overriding the default HTTP transport the client will use.
 	Transport http.RoundTripper
+	// HTTP2 if set configures HTTP/2 on the transport, including h2c and connection health checks.
+	HTTP2 *HTTP2Config
 	// TransportModifier can modify the transport after the client has applied other config settings
 	TransportModifier func(Transport *http.Transport)
 	// Tracer allows http stats tracing to be enabled.
 	Tracer tracer
 	// DialContext allows a dial context to be injected into the HTTP transport.
 	DialContext func(ctx context.Context, network string, addr string) (net.Conn, error)
-	// NoRateLimitBackoff disables the circuit breaker behaviour when the client receives a 429
-	// response. This is useful if the server rate-limits more granularly than requests-per-client
+	// NoRateLimitBackoff disables the client wide backoff when the client receives a 429
+	// response, or a 503 with a Retry-After header. This is useful if the server rate-limits more granularly than requests-per-client
 	// (e.g. if it uses specific query params or headers when bucketing requests) but should be enabled
 	// with care as it can lead to thrashing the server if not used appropriately.
 	NoRateLimitBackoff bool
+	// MaxRetryAfter caps the delay honoured from the Retry-After header of a 429 or 503 response,
+	// the default is 1 minute.
+	MaxRetryAfter time.Duration
+	// RateLimit if set limits the rate of requests made to each route. Calls wait for the
+	// rate limit before each attempt.
+	RateLimit *RateLimitConfig
+	// CircuitBreaker if set enables a circuit breaker for the client, or for each route.
+	// While the breaker is open calls fail with ErrCircuitOpen without calling the server.
+	CircuitBreaker *BreakerConfig
+	// ErrorDecoder if set decodes the body of non 2XX responses into an error that is attached
+	// to the returned HTTPError. It can be overridden for each request with ErrorBody.
+	ErrorDecoder ErrorDecoder
+	// Cache if set enables a private cache of GET responses, which respects the Cache-Control,
+	// Expires, ETag and Last-Modified response headers. Decoders are called with the cached body.
+	Cache *CacheConfig
 	// DisableW3CTracePropagation is a temporary option to disable sending w3c trace propagation headers
 	DisableW3CTracePropagation bool
 }
@@ -77,17 +102,25 @@ type Client struct {
 	baseURL               string
 	httpClient            *http.Client
 	backOffMaxElapsedTime time.Duration
-	authToken             string
+	authToken             secret.String
 	authHeader            string
+	auth                  AuthProvider
 	acceptType            string
 	additionalHeaders     map[string]string
 	tracer                tracer
 	noRateLimitBackoff    bool
+	maxRetryAfter         time.Duration
+	rateLimiter           *rateLimiter
+	breaker               *CircuitBreaker
+	latencies             latencies
+	cache                 *responseCache
+	balancer              *balancer
+	errorDecoder          ErrorDecoder
 	// temporary - whilst we cut over to otel and a shared dataset
 	disableW3CTracePropagation bool
 
-	mu      sync.RWMutex
-	last429 time.Time
+	mu           sync.RWMutex
+	backoffUntil time.Time
 
 	now func() time.Time // purely a test hook
 }
@@ -104,12 +137,21 @@ func New(cfg Config) *Client {
 		transport.DialContext = cfg.DialContext
 		cfg.Transport = transport
 	}
+	if cfg.HTTP2 != nil {
+		if transport, ok := cfg.Transport.(*http.Transport); ok {
+			cfg.HTTP2.apply(transport)
+		}
+	}
 	if cfg.TransportModifier != nil {
 		if transport, ok := cfg.Transport.(*http.Transport); ok {
 			cfg.TransportModifier(transport)
 		}
 	}
 
+	if cfg.MaxRetryAfter == 0 {
+		cfg.MaxRetryAfter = time.Minute
+	}
+
 	additionalHeaders := make(map[string]string)
 	ua := cfg.UserAgent
 	if ua == "" {
@@ -121,13 +163,14 @@ func New(cfg Config) *Client {
 	if cfg.Tracer != nil {
 		roundTripper = cfg.Tracer.Wrap(cfg.Name, roundTripper)
 	}
-	return &Client{
+	c := &Client{
 		name:                  cfg.Name,
 		baseURL:               cfg.BaseURL,
 		backOffMaxElapsedTime: cfg.Timeout,
 		authHeader:            cfg.AuthHeader,
 		additionalHeaders:     additionalHeaders,
-		authToken:             cfg.AuthToken,
+		authToken:             secret.String(cfg.AuthToken),
+		auth:                  cfg.Auth,
 		acceptType:            cfg.AcceptType,
 		httpClient: &http.Client{
 			Transport: roundTripper,
@@ -135,8 +178,25 @@ func New(cfg Config) *Client {
 		tracer:                     cfg.Tracer,
 		now:                        time.Now,
 		noRateLimitBackoff:         cfg.NoRateLimitBackoff,
+		maxRetryAfter:              cfg.MaxRetryAfter,
+		rateLimiter:                newRateLimiter(cfg.RateLimit),
+		cache:                      newResponseCache(cfg.Cache),
+		errorDecoder:               cfg.ErrorDecoder,
 		disableW3CTracePropagation: cfg.DisableW3CTracePropagation,
 	}
+	c.breaker = newCircuitBreaker(cfg.Name, cfg.CircuitBreaker, func() time.Time { return c.now() })
+	c.balancer = newBalancer(cfg.Endpoints, func() time.Time { return c.now() })
+	if c.balancer != nil {
+		// requests are made relative to the endpoint chosen for each attempt
+		c.baseURL = ""
+	}
+	return c
+}
+
+// CircuitBreaker returns the client's circuit breaker, which can be passed to system.AddGauges.
+// If the circuit breaker is not configured it reports no gauges.
+func (c *Client) CircuitBreaker() *CircuitBreaker {
+	return c.breaker
 }
 
 func UnixTransport(socket string) *http.Transport {
@@ -164,13 +224,21 @@ type Request struct {
 	body    interface{} // If set this will be sent as JSON
 	rawBody []byte      // If set this will be sent as is
 
+	bodyFn        func() (io.Reader, error) // If set will be called to stream the body on each attempt
+	contentLength int64                     // The length of the streamed body if known
+
 	decoders       map[int]decoder          // If set will be used to decode the response body by http status code
 	headerFn       func(header http.Header) // If set will be called with the response header
+	stream         func(body io.Reader, header http.Header) error
 	cookie         *http.Cookie
 	headers        map[string]string
 	timeout        time.Duration // The individual per call timeout
 	maxElapsedTime time.Duration // The total timeout for the entire request including retries
 	retry          bool
+	hedge          *HedgeConfig
+	idempotent     bool
+	cached         *cacheEntry // If set the stale cached response is revalidated
+	errorDecoder   ErrorDecoder
 	query          url.Values
 	rawquery       string
 
@@ -398,9 +466,9 @@ func (c *Client) Call(ctx context.Context, r Request) (err error) {
 		}
 		if c.authToken != "" {
 			if c.authHeader != "" {
-				req.Header.Set(c.authHeader, c.authToken)
+				req.Header.Set(c.authHeader, c.authToken.Raw())
 			} else {
-				req.Header.Set("Authorization", "Bearer "+c.authToken)
+				req.Header.Set("Authorization", "Bearer "+c.authToken.Raw())
 			}
 		}
 
@@ -427,17 +495,42 @@ func (c *Client) Call(ctx context.Context, r Request) (err error) {
 				return nil, fmt.Errorf("could not json encode Request: %w", err)
 			}
 			req.Body = io.NopCloser(b)
+			req.ContentLength = int64(b.Len())
 		}
 
 		if r.rawBody != nil {
 			b := bytes.NewReader(r.rawBody)
 			req.Body = io.NopCloser(b)
+			req.ContentLength = int64(len(r.rawBody))
+		}
+
+		if r.bodyFn != nil {
+			req.Body, req.GetBody, err = r.newBody()
+			if err != nil {
+				return nil, err
+			}
+			req.ContentLength = r.contentLength
 		}
 
 		return req, nil
 	}
 
+	if c.cache.cacheable(r) {
+		req, err := newRequestFn()
+		if err != nil {
+			return err
+		}
+		entry, fresh := c.cache.get(r, req, c.now())
+		if fresh {
+			return c.cacheHit(ctx, spanName, r, entry)
+		}
+		r.cached = entry
+	}
+
 	err = c.retryRequest(ctx, spanName, r, newRequestFn)
+	if c.reauthenticate(ctx, err) {
+		err = c.retryRequest(ctx, spanName, r, newRequestFn)
+	}
 	// remove the special retry status to resume normal error/warning behaviour
 	return doneRetrying(err)
 }
@@ -446,9 +539,30 @@ func (c *Client) Call(ctx context.Context, r Request) (err error) {
 // Any response body in non 2XX cases is discarded.
 // nolint: funlen, gocyclo
 func (c *Client) retryRequest(ctx context.Context, name string, r Request, newReq func() (*http.Request, error)) error {
+	var mu sync.Mutex
 	attemptCounter := 0
+	// tried is the endpoints already used by the call, so that retries can prefer another
+	tried := map[*endpoint]bool{}
+	// decoded is set once an attempt has claimed the response decoders, so that when hedging
+	// only the winning attempt decodes its response. Neither lock is held by the winner while
+	// it decodes, so losing attempts never wait for it.
+	var decodeMu sync.Mutex
+	decoded := false
+	// failMu is held while an error response is decoded, so that the winner can wait for an
+	// attempt already decoding one before it decodes its own response.
+	var failMu sync.Mutex
+	// lost reports whether another attempt has won, or the hedged call has finished, in which
+	// case the attempt must not touch the decoders as the call may already have returned.
+	lost := func(ctx context.Context, hedge int) bool {
+		decodeMu.Lock()
+		defer decodeMu.Unlock()
+		return decoded || (hedge > 0 && ctx.Err() != nil)
+	}
 
-	attempt := func() (err error) {
+	attempt := func(ctx context.Context, hedge int) (err error) {
+		hedgeCtx := ctx
+		// result is the outcome of the attempt recorded by the circuit breaker
+		result := outcomeIgnored
 		ctx, span := o11y.StartSpan(ctx, name, o11y.WithSpanKind(o11y.SpanKindClient))
 		defer o11y.End(span, &err)
 		before := time.Now()
@@ -456,16 +570,78 @@ func (c *Client) retryRequest(ctx context.Context, name string, r Request, newRe
 		if r.flatten != "" {
 			span.Flatten("hc_" + r.flatten)
 		}
+		mu.Lock()
 		attemptCounter++
+		attemptNum := attemptCounter
+		mu.Unlock()
+		if hedge > 0 {
+			span.AddRawField("http.hedge", hedge)
+			span.AddRawField("http.hedge_won", false)
+		}
 
 		if c.shouldBackoff() {
 			return backoff.Permanent(ErrServerBackoff)
 		}
 
+		if br := c.breaker.get(r.route); br != nil {
+			state, generation, err := br.allow()
+			span.AddRawField("http.circuit_breaker.state", state.String())
+			if err != nil {
+				return backoff.Permanent(err)
+			}
+			defer func() {
+				br.record(generation, result)
+			}()
+		}
+
+		if lim := c.rateLimiter.get(r.route); lim != nil {
+			start := time.Now()
+			if err := lim.Wait(ctx); err != nil {
+				return backoff.Permanent(fmt.Errorf("rate limit: %w", err))
+			}
+			span.AddRawField("http.rate_limit_wait_ms", time.Since(start).Milliseconds())
+		}
+
 		req, err := newReq()
 		if err != nil {
 			return backoff.Permanent(err)
 		}
+		// the transport closes the body once the request is sent, so if the attempt gives up
+		// before then it must be closed here
+		sent := false
+		defer func() {
+			if !sent && req.Body != nil {
+				_ = req.Body.Close()
+			}
+		}()
+		if r.cached != nil {
+			r.cached.addConditions(req)
+		}
+		// the cache is keyed by the URL before an endpoint is chosen
+		cacheURL := req.URL
+
+		baseURL := c.baseURL
+		if c.balancer != nil {
+			mu.Lock()
+			triedSoFar := maps.Clone(tried)
+			mu.Unlock()
+			ep, err := c.balancer.pick(ctx, triedSoFar)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			tried[ep] = true
+			mu.Unlock()
+			defer func() {
+				c.balancer.done(ep, result)
+			}()
+			baseURL = ep.baseURL
+			span.AddRawField("http.endpoint", baseURL)
+			req.URL, err = url.Parse(baseURL + req.URL.String())
+			if err != nil {
+				return backoff.Permanent(err)
+			}
+		}
 
 		// Add the per single http request timeout.
 		// This client is essentially for service to service calls, anyone is going to expect
@@ -474,29 +650,43 @@ func (c *Client) retryRequest(ctx context.Context, name string, r Request, newRe
 		if requestTimeout == 0 {
 			requestTimeout = time.Second * 5
 		}
-		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		// Streamed responses use the timeout as an idle timeout, so the body can take as long as
+		// it needs as long as it keeps making progress.
+		var cancel context.CancelFunc
+		var idle *idleTimeout
+		if r.stream != nil {
+			ctx, idle, cancel = newIdleTimeout(ctx, requestTimeout)
+		} else {
+			ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		}
 		defer cancel()
 
 		if c.tracer != nil {
 			ctx = c.tracer.WithTracer(ctx, r.route)
 		}
+		ctx, conn := withConnTrace(ctx)
 
 		req = req.WithContext(ctx)
 		if r.propagation {
 			c.addPropagationHeader(ctx, req)
 		}
 
+		if err := c.authenticate(ctx, req); err != nil {
+			return backoff.Permanent(err)
+		}
+
 		span.AddRawField("http.client_name", c.name)
 		span.AddRawField("http.route", r.route)
-		span.AddRawField("http.base_url", c.baseURL)
-		addReqToSpan(span, req, attemptCounter)
+		span.AddRawField("http.base_url", baseURL)
+		addReqToSpan(span, req, attemptNum)
 		addSemconvRequestAttrs(span, requestVals{
 			Req:        req,
 			Route:      r.route,
-			Attempt:    attemptCounter,
+			Attempt:    attemptNum,
 			ClientName: c.name,
 		})
 
+		sent = true
 		res, err := c.httpClient.Do(req)
 		if err != nil {
 			// url errors repeat the method and url which clutters metrics and logging
@@ -504,6 +694,11 @@ func (c *Client) retryRequest(ctx context.Context, name string, r Request, newRe
 			if errors.As(err, &e) {
 				err = e.Err
 			}
+			err = idle.mapErr(err)
+			// a cancelled request says nothing about the health of the server
+			if errors.Is(err, context.DeadlineExceeded) || !errors.Is(ctx.Err(), context.Canceled) {
+				result = outcomeFailure
+			}
 			return fmt.Errorf("httpclient do: %w", err)
 		}
 
@@ -523,39 +718,100 @@ func (c *Client) retryRequest(ctx context.Context, name string, r Request, newRe
 					"http.route:" + r.route,
 					"http.method:" + r.method,
 					"http.status_code:" + strconv.Itoa(res.StatusCode),
-					"http.retry:" + strconv.FormatBool(attemptCounter > 1),
+					"http.retry:" + strconv.FormatBool(attemptNum > 1),
 				},
 				1,
 			)
 		}
 		addRespToSpan(span, res)
 		addSemconvResponseAttrs(span, res)
+		span.AddRawField("http.conn_reused", conn.reused.Load())
+		if c.cache.cacheable(r) {
+			var cacheResult string
+			cacheReq := *req
+			cacheReq.URL = cacheURL
+			res, cacheResult = c.cache.response(&cacheReq, res, r.cached, c.now())
+			span.AddRawField("http.cache", cacheResult)
+			c.recordCache(ctx, r, cacheResult)
+		}
+		result = outcomeSuccess
+		if res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests {
+			result = outcomeFailure
+		}
 
-		err = extractHTTPError(req, res, attemptCounter, r.route)
+		err = extractHTTPError(req, res, attemptNum, r.route)
 		if err != nil {
-			if HasStatusCode(err, http.StatusTooManyRequests) {
-				c.setLast429()
+			var wait time.Duration
+			wait, err = c.serverBackoff(err, res)
+			if wait > 0 {
+				span.AddRawField("http.retry_after_ms", wait.Milliseconds())
 			}
 
+			errorDecoder := r.errorDecoder
+			if errorDecoder == nil {
+				errorDecoder = c.errorDecoder
+			}
+			err = decodeErrorBody(errorDecoder, err, res)
+
 			// attempt to decode a failure message if registered
 			// keep the primary http error, but add the decode error to the span
-			errDecode := r.decodeBody(res, false)
-			if errDecode != nil {
-				span.AddRawField("fail_decoder_error", errDecode)
+			failMu.Lock()
+			if !lost(hedgeCtx, hedge) {
+				if errDecode := r.decodeBody(res, false); errDecode != nil {
+					span.AddRawField("fail_decoder_error", errDecode)
+				}
 			}
+			failMu.Unlock()
 
 			return err
 		}
 
+		if r.hedge != nil {
+			c.latencies.record(r.route, time.Since(before))
+		}
+
+		decodeMu.Lock()
+		if decoded || (hedge > 0 && hedgeCtx.Err() != nil) {
+			decodeMu.Unlock()
+			return errHedgeLost
+		}
+		decoded = true
+		decodeMu.Unlock()
+		// wait for any error response being decoded, no more will be once this has won
+		failMu.Lock()
+		failMu.Unlock() //nolint:staticcheck // an empty critical section to wait for the lock
+		defer func() {
+			if err != nil {
+				// let a later attempt decode its response if this one failed to
+				decodeMu.Lock()
+				decoded = false
+				decodeMu.Unlock()
+			}
+			if err == nil && hedge > 0 {
+				span.AddRawField("http.hedge_won", true)
+			}
+		}()
+
 		if r.headerFn != nil {
 			r.headerFn(res.Header)
 		}
 
+		if r.stream != nil {
+			idle.touch()
+			return r.streamBody(res, idle)
+		}
 		return r.decodeBody(res, true)
 	}
 
+	run := func() error {
+		if r.hedge != nil {
+			return c.hedged(ctx, r, attempt)
+		}
+		return attempt(ctx, 0)
+	}
+
 	if !r.retry {
-		return attempt()
+		return run()
 	}
 
 	bo := backoff.NewExponentialBackOff()
@@ -566,7 +822,7 @@ func (c *Client) retryRequest(ctx context.Context, name string, r Request, newRe
 	}
 
 	_, err := backoff.Retry(ctx, func() (any, error) {
-		err := attempt()
+		err := run()
 		return nil, err
 	}, backoff.WithBackOff(bo), backoff.WithMaxElapsedTime(maxElapsedTime))
 
@@ -619,9 +875,13 @@ func addReqToSpan(span o11y.Span, req *http.Request, attempt int) {
 	span.AddRawField("http.url", req.URL.String())
 	span.AddRawField("http.user_agent", req.UserAgent())
 	span.AddRawField("http.request_content_length", req.ContentLength)
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		span.AddRawField("http.request_content_type", ct)
+	}
 }
 
 func addRespToSpan(span o11y.Span, res *http.Response) {
+	span.AddRawField("http.protocol", res.Proto)
 	if cl := res.Header.Get("Content-Length"); cl != "" {
 		span.AddRawField("http.response_content_length", cl)
 	}
@@ -647,15 +907,17 @@ func (c *Client) shouldBackoff() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	// If not yet 10 seconds since the last 429
-	return c.now().Before(c.last429.Add(time.Second * 10))
+	return c.now().Before(c.backoffUntil)
 }
 
-func (c *Client) setLast429() {
+// setBackoffFor makes the client back off for at least d.
+func (c *Client) setBackoffFor(d time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.last429 = c.now()
+	if until := c.now().Add(d); until.After(c.backoffUntil) {
+		c.backoffUntil = until
+	}
 }
 
 // NewJSONDecoder returns a decoder func enclosing the resp param
@@ -702,6 +964,7 @@ type HTTPError struct {
 	code         int
 	attempts     int
 	doneRetrying bool
+	body         error
 }
 
 var _ error = (*HTTPError)(nil)
@@ -710,8 +973,12 @@ func (e *HTTPError) Error() string {
 	if e == nil {
 		return "<nil>"
 	}
-	return fmt.Sprintf("the response from %s %s was %d (%s) (%d attempts)",
+	msg := fmt.Sprintf("the response from %s %s was %d (%s) (%d attempts)",
 		e.method, e.route, e.code, http.StatusText(e.code), e.attempts)
+	if e.body != nil {
+		msg += ": " + e.body.Error()
+	}
+	return msg
 }
 
 // Code returns the status code recorded in this error.
@@ -719,6 +986,11 @@ func (e *HTTPError) Code() int {
 	return e.code
 }
 
+// Unwrap returns the error decoded from the response body by the ErrorDecoder, if there is one.
+func (e *HTTPError) Unwrap() error {
+	return e.body
+}
+
 // Is checks that this error is being checked for the special o11y error that is not
 // added to the trace as an error. If the error is due to relatively expected failure response codes
 // return true so it does not appear in the traces as an error.
@@ -803,6 +1075,14 @@ func (r Request) validate() error {
 		return errors.New("cannot have both body and raw body be set")
 	}
 
+	if r.bodyFn != nil && (r.body != nil || r.rawBody != nil) {
+		return errors.New("cannot have a body reader with a body or raw body")
+	}
+
+	if r.hedge != nil && !r.isIdempotent() {
+		return fmt.Errorf("cannot hedge a non idempotent %s request", r.method)
+	}
+
 	return nil
 }
 
@@ -823,5 +1103,5 @@ func (r Request) validateGetWithBody() bool {
 }
 
 func (r Request) hasBody() bool {
-	return r.body != nil || r.rawBody != nil
+	return r.body != nil || r.rawBody != nil || r.bodyFn != nil
 }
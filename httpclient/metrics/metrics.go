@@ -23,6 +23,10 @@ type Metrics struct {
 	poolAvailable atomic.Int64 // an estimate based on reference counting
 	inFlight      int64
 	inFlightMax   int64
+	// protocols counts responses by HTTP version, and conns counts connections by whether they
+	// were reused, both since the gauges were last collected.
+	protocols map[string]int64
+	conns     map[bool]int64
 }
 
 // New creates a new Metrics for capturing metrics from http trace.
@@ -35,7 +39,9 @@ func New(ctx context.Context) *Metrics {
 	}
 
 	return &Metrics{
-		prov: prov,
+		prov:      prov,
+		protocols: map[string]int64{},
+		conns:     map[bool]int64{},
 	}
 }
 
@@ -66,7 +72,13 @@ func (m *Metrics) RoundTrip(req *http.Request) (*http.Response, error) {
 	}()
 
 	// *important* that this is done outside the lock
-	return m.rt.RoundTrip(req)
+	res, err := m.rt.RoundTrip(req)
+	if err == nil {
+		m.mu.Lock()
+		m.protocols[res.Proto]++
+		m.mu.Unlock()
+	}
+	return res, err
 }
 
 func (m *Metrics) GaugeName() string {
@@ -85,7 +97,26 @@ func (m *Metrics) Gauges(_ context.Context) map[string][]system.TaggedValue {
 		poolAvail = 0
 	}
 
+	protocols := []system.TaggedValue{}
+	for proto, n := range m.protocols {
+		protocols = append(protocols, system.TaggedValue{
+			Val:  float64(n),
+			Tags: append(tags, "protocol:"+proto),
+		})
+	}
+	clear(m.protocols)
+	conns := []system.TaggedValue{}
+	for reused, n := range m.conns {
+		conns = append(conns, system.TaggedValue{
+			Val:  float64(n),
+			Tags: append(tags, fmt.Sprintf("conn_reused:%t", reused)),
+		})
+	}
+	clear(m.conns)
+
 	return map[string][]system.TaggedValue{
+		"responses_by_protocol": protocols,
+		"conns":                 conns,
 		"in_flight": {
 			{
 				Val:  float64(m.inFlight),
@@ -227,6 +258,10 @@ func (r *request) gotCon(info httptrace.GotConnInfo) {
 	}
 	r.conDoneAt = time.Now()
 
+	r.m.mu.Lock()
+	r.m.conns[info.Reused]++
+	r.m.mu.Unlock()
+
 	commonTags := append(r.commonTags, "hostport:"+r.con.host)
 
 	tags := map[string]string{
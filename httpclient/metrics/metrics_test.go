@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -200,6 +201,43 @@ func TestMetrics(t *testing.T) {
 	})
 }
 
+func TestMetrics_ProtocolAndReuse(t *testing.T) {
+	ctx := testcontext.Background()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(s.Close)
+
+	tracer := New(ctx)
+	cl := httpclient.New(httpclient.Config{
+		Name:    "test-client",
+		BaseURL: s.URL,
+		Tracer:  tracer,
+	})
+	for i := 0; i < 3; i++ {
+		assert.Assert(t, cl.Call(ctx, httpclient.NewRequest("GET", "/test")))
+	}
+
+	gauges := tracer.Gauges(ctx)
+	assert.Check(t, cmp.DeepEqual(gauges["responses_by_protocol"], []system.TaggedValue{
+		{Val: 3, Tags: []string{"client:test-client", "protocol:HTTP/1.1"}},
+	}))
+	assert.Check(t, cmp.Len(gauges["conns"], 2))
+	for _, g := range gauges["conns"] {
+		want := float64(2)
+		if slices.Contains(g.Tags, "conn_reused:false") {
+			want = 1
+		}
+		assert.Check(t, cmp.Equal(g.Val, want), g.Tags)
+	}
+
+	t.Run("counts are reset when collected", func(t *testing.T) {
+		gauges := tracer.Gauges(ctx)
+		assert.Check(t, cmp.Len(gauges["responses_by_protocol"], 0))
+		assert.Check(t, cmp.Len(gauges["conns"], 0))
+	})
+}
+
 func assertIn(t *testing.T, ls []fakestatsd.Metric, what string, min, max int) {
 	t.Helper()
 	count := 0
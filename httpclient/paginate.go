@@ -0,0 +1,291 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/circleci/ex/o11y"
+)
+
+// Pager decodes the items from each page of a paginated response, and works out the request
+// for the next page. Use LinkPages, TokenPages or OffsetPages to create one.
+type Pager[T any] struct {
+	decode func(body io.Reader) (items []T, token string, err error)
+	first  func(r Request) Request
+	next   func(c *Client, r Request, p page[T]) (Request, bool, error)
+}
+
+type page[T any] struct {
+	items  []T
+	token  string
+	header http.Header
+}
+
+// PageLimits bound the number of pages or items that Paginate fetches. Zero means no limit.
+type PageLimits struct {
+	MaxPages int
+	MaxItems int
+}
+
+// LinkPages follows the rel="next" URL in the Link header of each page (RFC 8288), until a
+// page has no next link. The next URL must be under the client BaseURL, or the base URL of one
+// of the endpoints if they are configured.
+func LinkPages[T any](decode func(body io.Reader) ([]T, error)) Pager[T] {
+	return Pager[T]{
+		decode: func(body io.Reader) ([]T, string, error) {
+			items, err := decode(body)
+			return items, "", err
+		},
+		next: func(c *Client, r Request, p page[T]) (Request, bool, error) {
+			link := nextLink(p.header)
+			if link == "" {
+				return r, false, nil
+			}
+			// with endpoints the page may have come from any of them, and they all serve the
+			// same paths
+			bases := []string{c.baseURL}
+			if c.balancer != nil {
+				bases = c.balancer.baseURLs()
+			}
+			var (
+				path, query string
+				ok          bool
+				err         error
+			)
+			for _, base := range bases {
+				path, query, ok, err = linkUnder(base, link)
+				if err != nil || ok {
+					break
+				}
+			}
+			if err != nil {
+				return r, false, err
+			}
+			if !ok {
+				return r, false, fmt.Errorf("next link %q is not under the base url", link)
+			}
+			r.url = path
+			r.query = url.Values{}
+			r.rawquery = query
+			return r, true, nil
+		},
+	}
+}
+
+// linkUnder resolves the link against the base URL, and returns its path relative to the base
+// if it is under it.
+func linkUnder(baseURL, link string) (path, query string, ok bool, err error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", "", false, err
+	}
+	u, err := base.Parse(link)
+	if err != nil {
+		return "", "", false, fmt.Errorf("next link: %w", err)
+	}
+	query = u.RawQuery
+	u.RawQuery = ""
+	path, ok = strings.CutPrefix(u.String(), strings.TrimSuffix(baseURL, "/"))
+	return path, query, ok, nil
+}
+
+// TokenPages sends the next page token returned by decode in the param query parameter, until
+// decode returns an empty token.
+func TokenPages[T any](param string, decode func(body io.Reader) (items []T, next string, err error)) Pager[T] {
+	return Pager[T]{
+		decode: decode,
+		next: func(_ *Client, r Request, p page[T]) (Request, bool, error) {
+			if p.token == "" {
+				return r, false, nil
+			}
+			r.query = cloneQuery(r.query)
+			r.query.Set(param, p.token)
+			return r, true, nil
+		},
+	}
+}
+
+// OffsetPages requests limit items at a time using the offsetParam and limitParam query
+// parameters, until a page has fewer than limit items.
+func OffsetPages[T any](offsetParam, limitParam string, limit int, decode func(body io.Reader) ([]T, error)) Pager[T] {
+	offset := func(r Request, n int) Request {
+		r.query = cloneQuery(r.query)
+		r.query.Set(offsetParam, strconv.Itoa(n))
+		r.query.Set(limitParam, strconv.Itoa(limit))
+		return r
+	}
+	return Pager[T]{
+		decode: func(body io.Reader) ([]T, string, error) {
+			items, err := decode(body)
+			return items, "", err
+		},
+		first: func(r Request) Request {
+			return offset(r, 0)
+		},
+		next: func(_ *Client, r Request, p page[T]) (Request, bool, error) {
+			if len(p.items) < limit {
+				return r, false, nil
+			}
+			n, _ := strconv.Atoi(r.query.Get(offsetParam))
+			return offset(r, n+len(p.items)), true, nil
+		},
+	}
+}
+
+// Paginate calls the request, and the request for each following page, yielding the items
+// decoded from each page. It stops after the last page, when the limits are reached, when the
+// loop is broken out of, or at the first error, which is yielded.
+//
+// Any decoders on the request are replaced by the pager. The pages are fetched under a parent
+// span, with a child span for each page.
+//
+// Example:
+//
+//	for fruit, err := range httpclient.Paginate(ctx, client, httpclient.NewRequest("GET", "/api/fruit"),
+//	  httpclient.LinkPages(func(body io.Reader) (fruit []Fruit, err error) {
+//	    return fruit, json.NewDecoder(body).Decode(&fruit)
+//	  }),
+//	  httpclient.PageLimits{MaxItems: 1000},
+//	) {
+//	  if err != nil {
+//	    return err
+//	  }
+//	  ...
+//	}
+func Paginate[T any](ctx context.Context, c *Client, r Request, pager Pager[T], limits PageLimits) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var err error
+		ctx, span := o11y.StartSpan(ctx, fmt.Sprintf("paginate %s %s", r.method, r.route))
+		defer o11y.End(span, &err)
+		span.AddField("client_name", c.name)
+		span.AddField("route", r.route)
+
+		pages, items := 0, 0
+		defer func() {
+			span.AddField("pages", pages)
+			span.AddField("items", items)
+		}()
+
+		if pager.first != nil {
+			r = pager.first(r)
+		}
+		for {
+			// check the items too, so a page is not fetched just to be thrown away
+			if (limits.MaxPages > 0 && pages >= limits.MaxPages) ||
+				(limits.MaxItems > 0 && items >= limits.MaxItems) {
+				span.AddField("limited", true)
+				return
+			}
+			if err = ctx.Err(); err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+
+			var p page[T]
+			p, err = fetchPage(ctx, c, r, pager, pages+1)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			pages++
+
+			for _, item := range p.items {
+				if limits.MaxItems > 0 && items >= limits.MaxItems {
+					span.AddField("limited", true)
+					return
+				}
+				items++
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			var more bool
+			r, more, err = pager.next(c, r, p)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			if !more {
+				return
+			}
+		}
+	}
+}
+
+func fetchPage[T any](ctx context.Context, c *Client, r Request, pager Pager[T], number int) (p page[T], err error) {
+	ctx, span := o11y.StartSpan(ctx, "page")
+	defer o11y.End(span, &err)
+	span.AddField("page", number)
+
+	r.decoders = maps.Clone(r.decoders)
+	if r.decoders == nil {
+		r.decoders = map[int]decoder{}
+	}
+	r.decoders[successDecodeStatus] = func(body io.Reader) error {
+		var err error
+		p.items, p.token, err = pager.decode(body)
+		return err
+	}
+	headerFn := r.headerFn
+	r.headerFn = func(header http.Header) {
+		p.header = header
+		if headerFn != nil {
+			headerFn(header)
+		}
+	}
+
+	err = c.Call(ctx, r)
+	span.AddField("items", len(p.items))
+	return p, err
+}
+
+// nextLink returns the rel="next" URL from the Link header, if there is one.
+func nextLink(h http.Header) string {
+	for _, v := range h.Values("Link") {
+		for _, link := range strings.Split(v, ",") {
+			target, params, ok := strings.Cut(link, ";")
+			if !ok {
+				continue
+			}
+			target = strings.TrimSpace(target)
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				k, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.EqualFold(k, "rel") && hasRel(strings.Trim(val, `"`), "next") {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func hasRel(rels, rel string) bool {
+	for _, r := range strings.Fields(rels) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneQuery(q url.Values) url.Values {
+	c := url.Values{}
+	for k, v := range q {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
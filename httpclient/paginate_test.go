@@ -0,0 +1,211 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestPaginate(t *testing.T) {
+	ctx := testcontext.Background()
+
+	const total = 7
+	pageOf := func(from, n int) []int {
+		var items []int
+		for i := from; i < min(from+n, total); i++ {
+			items = append(items, i)
+		}
+		return items
+	}
+
+	mux := http.NewServeMux()
+	var linkCalls atomic.Int64
+	mux.HandleFunc("GET /api/link", func(w http.ResponseWriter, r *http.Request) {
+		linkCalls.Add(1)
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		if from+3 < total {
+			w.Header().Add("Link", fmt.Sprintf(`</api/link?from=%d>; rel="next", </api/link?from=6>; rel="last"`, from+3))
+		}
+		_ = json.NewEncoder(w).Encode(pageOf(from, 3))
+	})
+	mux.HandleFunc("GET /api/absolute", func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		if from+3 < total {
+			w.Header().Add("Link", fmt.Sprintf(`<http://%s/api/absolute?from=%d>; rel="next"`, r.Host, from+3))
+		}
+		_ = json.NewEncoder(w).Encode(pageOf(from, 3))
+	})
+	mux.HandleFunc("GET /api/foreign", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `<http://example.com/api/foreign?from=3>; rel="next"`)
+		_ = json.NewEncoder(w).Encode(pageOf(0, 3))
+	})
+	mux.HandleFunc("GET /api/token", func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.Atoi(r.URL.Query().Get("page_token"))
+		next := ""
+		if from+3 < total {
+			next = strconv.Itoa(from + 3)
+		}
+		_ = json.NewEncoder(w).Encode(tokenPage{Items: pageOf(from, 3), Next: next})
+	})
+	mux.HandleFunc("GET /api/offset", func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		assert.Check(t, cmp.Equal(r.URL.Query().Get("colour"), "red"))
+		_ = json.NewEncoder(w).Encode(pageOf(offset, limit))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := New(Config{
+		BaseURL: server.URL + "/api",
+		Timeout: 5 * time.Second,
+	})
+
+	decodeInts := func(body io.Reader) (items []int, err error) {
+		return items, json.NewDecoder(body).Decode(&items)
+	}
+	decodeTokenPage := func(body io.Reader) ([]int, string, error) {
+		var p tokenPage
+		err := json.NewDecoder(body).Decode(&p)
+		return p.Items, p.Next, err
+	}
+	collect := func(t *testing.T, seq func(func(int, error) bool)) []int {
+		t.Helper()
+		var got []int
+		for item, err := range seq {
+			assert.NilError(t, err)
+			got = append(got, item)
+		}
+		return got
+	}
+	all := []int{0, 1, 2, 3, 4, 5, 6}
+
+	t.Run("link", func(t *testing.T) {
+		got := collect(t, Paginate(ctx, client, NewRequest("GET", "/link"), LinkPages(decodeInts), PageLimits{}))
+		assert.Check(t, cmp.DeepEqual(got, all))
+	})
+
+	t.Run("token", func(t *testing.T) {
+		got := collect(t, Paginate(ctx, client, NewRequest("GET", "/token"),
+			TokenPages("page_token", decodeTokenPage), PageLimits{}))
+		assert.Check(t, cmp.DeepEqual(got, all))
+	})
+
+	t.Run("offset", func(t *testing.T) {
+		req := NewRequest("GET", "/offset", QueryParam("colour", "red"))
+		got := collect(t, Paginate(ctx, client, req, OffsetPages("offset", "limit", 2, decodeInts), PageLimits{}))
+		assert.Check(t, cmp.DeepEqual(got, all))
+	})
+
+	t.Run("max pages", func(t *testing.T) {
+		got := collect(t, Paginate(ctx, client, NewRequest("GET", "/link"), LinkPages(decodeInts),
+			PageLimits{MaxPages: 2}))
+		assert.Check(t, cmp.DeepEqual(got, []int{0, 1, 2, 3, 4, 5}))
+	})
+
+	t.Run("max items", func(t *testing.T) {
+		got := collect(t, Paginate(ctx, client, NewRequest("GET", "/link"), LinkPages(decodeInts),
+			PageLimits{MaxItems: 4}))
+		assert.Check(t, cmp.DeepEqual(got, []int{0, 1, 2, 3}))
+	})
+
+	t.Run("max items at the end of a page", func(t *testing.T) {
+		linkCalls.Store(0)
+		got := collect(t, Paginate(ctx, client, NewRequest("GET", "/link"), LinkPages(decodeInts),
+			PageLimits{MaxItems: 3}))
+		assert.Check(t, cmp.DeepEqual(got, []int{0, 1, 2}))
+		assert.Check(t, cmp.Equal(linkCalls.Load(), int64(1)))
+	})
+
+	t.Run("break", func(t *testing.T) {
+		var got []int
+		for item, err := range Paginate(ctx, client, NewRequest("GET", "/link"), LinkPages(decodeInts), PageLimits{}) {
+			assert.NilError(t, err)
+			got = append(got, item)
+			if item == 1 {
+				break
+			}
+		}
+		assert.Check(t, cmp.DeepEqual(got, []int{0, 1}))
+	})
+
+	t.Run("errors are yielded", func(t *testing.T) {
+		var errs []error
+		for _, err := range Paginate(ctx, client, NewRequest("GET", "/missing"), LinkPages(decodeInts), PageLimits{}) {
+			errs = append(errs, err)
+		}
+		assert.Assert(t, cmp.Len(errs, 1))
+		assert.Check(t, HasStatusCode(errs[0], http.StatusNotFound))
+	})
+
+	t.Run("endpoints", func(t *testing.T) {
+		client := New(Config{
+			Timeout: 5 * time.Second,
+			Endpoints: &EndpointsConfig{
+				Static: []string{server.URL + "/api"},
+			},
+		})
+
+		t.Run("relative link", func(t *testing.T) {
+			got := collect(t, Paginate(ctx, client, NewRequest("GET", "/link"), LinkPages(decodeInts), PageLimits{}))
+			assert.Check(t, cmp.DeepEqual(got, all))
+		})
+
+		t.Run("absolute link", func(t *testing.T) {
+			got := collect(t, Paginate(ctx, client, NewRequest("GET", "/absolute"), LinkPages(decodeInts),
+				PageLimits{}))
+			assert.Check(t, cmp.DeepEqual(got, all))
+		})
+
+		t.Run("foreign link", func(t *testing.T) {
+			var got []int
+			var errs []error
+			for item, err := range Paginate(ctx, client, NewRequest("GET", "/foreign"), LinkPages(decodeInts),
+				PageLimits{}) {
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				got = append(got, item)
+			}
+			assert.Check(t, cmp.DeepEqual(got, []int{0, 1, 2}))
+			assert.Assert(t, cmp.Len(errs, 1))
+			assert.Check(t, cmp.ErrorContains(errs[0], "is not under the base url"))
+		})
+	})
+}
+
+type tokenPage struct {
+	Items []int  `json:"items"`
+	Next  string `json:"next"`
+}
+
+func TestNextLink(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{name: "none", header: http.Header{}},
+		{name: "next", header: http.Header{"Link": {`<https://a.test/x?page=2>; rel="next"`}}, want: "https://a.test/x?page=2"},
+		{name: "multiple rels", header: http.Header{"Link": {`</x?page=1>; rel="prev", </x?page=3>; rel="last next"`}}, want: "/x?page=3"},
+		{name: "no next", header: http.Header{"Link": {`</x?page=1>; rel="prev"`}}},
+		{name: "unquoted", header: http.Header{"Link": {`</x?page=2>; title="t"; rel=next`}}, want: "/x?page=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Check(t, cmp.Equal(nextLink(tt.header), tt.want))
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package httpclient
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+	"golang.org/x/time/rate"
+)
+
+// defaultServerBackoff is how long the client backs off after a 429 without a Retry-After header.
+const defaultServerBackoff = 10 * time.Second
+
+// RateLimitConfig configures a client side token bucket for each route, so that the client
+// stays under server limits rather than relying on 429 responses.
+// New panics if the Rate is not positive or the Burst is negative, as the client would
+// never be allowed to make calls.
+type RateLimitConfig struct {
+	// Rate is the number of requests per second allowed to each route.
+	Rate float64
+	// Burst is the number of requests that can be made at once, the default is 1.
+	Burst int
+}
+
+type rateLimiter struct {
+	cfg RateLimitConfig
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newRateLimiter(cfg *RateLimitConfig) *rateLimiter {
+	if cfg == nil {
+		return nil
+	}
+	c := *cfg
+	if c.Rate <= 0 {
+		panic("httpclient: rate limit Rate must be positive")
+	}
+	if c.Burst == 0 {
+		c.Burst = 1
+	}
+	if c.Burst < 1 {
+		panic("httpclient: rate limit Burst must be at least 1")
+	}
+	return &rateLimiter{
+		cfg:      c,
+		limiters: map[string]*rate.Limiter{},
+	}
+}
+
+// get returns the token bucket for the route, or nil if rate limiting is not enabled.
+func (l *rateLimiter) get(route string) *rate.Limiter {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lim, ok := l.limiters[route]
+	if !ok {
+		lim = rate.NewLimiter(rate.Limit(l.cfg.Rate), l.cfg.Burst)
+		l.limiters[route] = lim
+	}
+	return lim
+}
+
+// maxRetryAfterSecs is the largest Retry-After in seconds that fits in a time.Duration.
+const maxRetryAfterSecs = int64(math.MaxInt64 / time.Second)
+
+// parseRetryAfter parses a Retry-After header value, which is either a number of seconds or an
+// HTTP-date. A date in the past is a zero delay.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil || errors.Is(err, strconv.ErrRange) {
+		if secs < 0 {
+			return 0, false
+		}
+		// clamp huge values rather than overflow, the delay is capped by the caller anyway
+		return time.Duration(min(secs, maxRetryAfterSecs)) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
+// retryAfterError wraps an HTTPError so that the retry loop waits for the server
+// requested delay before the next attempt.
+type retryAfterError struct {
+	err  *HTTPError
+	wait time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// As allows the backoff retry loop to find the requested delay.
+func (e *retryAfterError) As(target any) bool {
+	if t, ok := target.(**backoff.RetryAfterError); ok {
+		*t = &backoff.RetryAfterError{Duration: e.wait}
+		return true
+	}
+	return false
+}
+
+// serverBackoff honours any Retry-After header sent with a 429 or 503 response, both for the
+// client wide backoff and for the retries of this request. A 429 without a Retry-After header
+// causes the client to back off for 10 seconds, and the request is not retried.
+func (c *Client) serverBackoff(err error, res *http.Response) (time.Duration, error) {
+	if !HasStatusCode(err, http.StatusTooManyRequests, http.StatusServiceUnavailable) {
+		return 0, err
+	}
+	wait, ok := parseRetryAfter(res.Header.Get("Retry-After"), c.now())
+	if !ok {
+		if res.StatusCode == http.StatusTooManyRequests {
+			c.setBackoffFor(defaultServerBackoff)
+		}
+		return 0, err
+	}
+	wait = min(wait, c.maxRetryAfter)
+	c.setBackoffFor(wait)
+
+	httpErr := &HTTPError{}
+	if !errors.As(err, &httpErr) {
+		return 0, err
+	}
+	return wait, &retryAfterError{err: httpErr, wait: wait}
+}
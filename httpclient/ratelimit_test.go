@@ -0,0 +1,164 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "nonsense", wantOK: false},
+		{value: "-1", wantOK: false},
+		{value: "0", want: 0, wantOK: true},
+		{value: " 120 ", want: 2 * time.Minute, wantOK: true},
+		{value: "9999999999999", want: time.Duration(maxRetryAfterSecs) * time.Second, wantOK: true},
+		{value: "99999999999999999999999", want: time.Duration(maxRetryAfterSecs) * time.Second, wantOK: true},
+		{value: now.Add(30 * time.Second).Format(http.TimeFormat), want: 30 * time.Second, wantOK: true},
+		{value: now.Add(-time.Hour).Format(http.TimeFormat), want: 0, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			assert.Check(t, cmp.Equal(ok, tt.wantOK))
+			assert.Check(t, cmp.Equal(got, tt.want))
+		})
+	}
+}
+
+func TestClient_Call_RetryAfter(t *testing.T) {
+	ctx := testcontext.Background()
+
+	var calls atomic.Int64
+	status := http.StatusServiceUnavailable
+	retryAfter := "1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	for _, code := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		t.Run(strconv.Itoa(code), func(t *testing.T) {
+			calls.Store(0)
+			status = code
+			client := New(Config{
+				BaseURL: server.URL,
+				Timeout: 5 * time.Second,
+			})
+
+			start := time.Now()
+			err := client.Call(ctx, NewRequest("GET", "/"))
+			assert.Check(t, err)
+			assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+			assert.Check(t, time.Since(start) >= time.Second)
+		})
+	}
+
+	t.Run("huge retry after is capped", func(t *testing.T) {
+		calls.Store(0)
+		status = http.StatusServiceUnavailable
+		retryAfter = "9999999999999"
+		t.Cleanup(func() { retryAfter = "1" })
+		client := New(Config{
+			BaseURL:       server.URL,
+			Timeout:       5 * time.Second,
+			MaxRetryAfter: 10 * time.Millisecond,
+		})
+
+		err := client.Call(ctx, NewRequest("GET", "/"))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(calls.Load(), int64(2)))
+	})
+
+	t.Run("retry after longer than the timeout", func(t *testing.T) {
+		calls.Store(0)
+		status = http.StatusServiceUnavailable
+		client := New(Config{
+			BaseURL: server.URL,
+			Timeout: 500 * time.Millisecond,
+		})
+		now := time.Now()
+		client.now = func() time.Time { return now }
+
+		err := client.Call(ctx, NewRequest("GET", "/"))
+		assert.Check(t, HasStatusCode(err, http.StatusServiceUnavailable), err)
+		assert.Check(t, cmp.Equal(calls.Load(), int64(1)))
+
+		// the client wide backoff is the Retry-After duration
+		err = client.Call(ctx, NewRequest("GET", "/"))
+		assert.Check(t, errors.Is(err, ErrServerBackoff), err)
+
+		now = now.Add(time.Second)
+		err = client.Call(ctx, NewRequest("GET", "/"))
+		assert.Check(t, err)
+	})
+}
+
+func TestClient_Call_RateLimit(t *testing.T) {
+	ctx := testcontext.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	client := New(Config{
+		BaseURL:   server.URL,
+		Timeout:   5 * time.Second,
+		RateLimit: &RateLimitConfig{Rate: 20},
+	})
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.Check(t, client.Call(ctx, NewRequest("GET", "/a")))
+	}
+	assert.Check(t, time.Since(start) >= 200*time.Millisecond)
+
+	t.Run("routes have their own bucket", func(t *testing.T) {
+		start := time.Now()
+		assert.Check(t, client.Call(ctx, NewRequest("GET", "/b")))
+		assert.Check(t, time.Since(start) < 50*time.Millisecond)
+	})
+}
+
+func TestNewRateLimiter_Invalid(t *testing.T) {
+	panics := func(cfg RateLimitConfig) (msg any) {
+		defer func() {
+			msg = recover()
+		}()
+		newRateLimiter(&cfg)
+		return nil
+	}
+
+	t.Run("zero rate", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(panics(RateLimitConfig{}), "httpclient: rate limit Rate must be positive"))
+	})
+
+	t.Run("negative rate", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(panics(RateLimitConfig{Rate: -1}), "httpclient: rate limit Rate must be positive"))
+	})
+
+	t.Run("negative burst", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(panics(RateLimitConfig{Rate: 1, Burst: -1}),
+			"httpclient: rate limit Burst must be at least 1"))
+	})
+}
@@ -47,6 +47,9 @@ func addSemconvResponseAttrs(span o11y.Span, res *http.Response) {
 	as := map[attribute.Key]any{
 		semconv.HTTPResponseStatusCodeKey: res.StatusCode,
 	}
+	if res.ProtoMajor > 0 {
+		as[semconv.NetworkProtocolVersionKey] = protocolVersion(res)
+	}
 	if res.StatusCode >= http.StatusBadRequest {
 		as[semconv.ErrorTypeKey] = strconv.Itoa(res.StatusCode)
 	}
@@ -103,3 +106,11 @@ func cleanURL(req *http.Request) url.URL {
 	}
 	return RedactQueryString(u)
 }
+
+// protocolVersion returns the semconv network protocol version, e.g. "1.1" or "2".
+func protocolVersion(res *http.Response) string {
+	if res.ProtoMajor >= 2 {
+		return strconv.Itoa(res.ProtoMajor)
+	}
+	return strconv.Itoa(res.ProtoMajor) + "." + strconv.Itoa(res.ProtoMinor)
+}
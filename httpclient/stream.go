@@ -0,0 +1,140 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+)
+
+// BodyReader sets a streamed request body. The factory is called for every attempt, and must
+// return a reader positioned at the start of the body each time, so that retries can resend it.
+// If the returned reader is an io.Closer it will be closed once the body has been sent.
+//
+// Example:
+//
+//	req := httpclient.NewRequest("PUT", "/artifacts/%s",
+//	  httpclient.RouteParams(name),
+//	  httpclient.BodyReader(func() (io.Reader, error) {
+//	    return os.Open(path)
+//	  }),
+//	  httpclient.ContentLength(size),
+//	)
+func BodyReader(factory func() (io.Reader, error)) func(*Request) {
+	return func(r *Request) {
+		r.bodyFn = factory
+	}
+}
+
+// ContentLength sets the length of a streamed request body. If it is not set the body is sent
+// using chunked encoding.
+func ContentLength(n int64) func(*Request) {
+	return func(r *Request) {
+		r.contentLength = n
+	}
+}
+
+// StreamResponse sets a handler that is given the successful response body to read directly,
+// without it being buffered. The handler is called once per attempt, if reading the body fails
+// part way through the attempt is retried, and the handler must discard anything it handled
+// in the previous attempt.
+//
+// For streamed responses the request Timeout is how long the client waits for the response
+// headers, and for each read of the body to make progress, rather than a limit on the whole
+// attempt. This allows large bodies to be streamed over slow connections.
+func StreamResponse(handler func(body io.Reader, header http.Header) error) func(*Request) {
+	return func(r *Request) {
+		r.stream = handler
+	}
+}
+
+// newBody calls the body factory, returning a body for the request along with a GetBody func
+// so that redirects can resend it.
+func (r Request) newBody() (io.ReadCloser, func() (io.ReadCloser, error), error) {
+	getBody := func() (io.ReadCloser, error) {
+		rd, err := r.bodyFn()
+		if err != nil {
+			return nil, fmt.Errorf("body reader: %w", err)
+		}
+		if rc, ok := rd.(io.ReadCloser); ok {
+			return rc, nil
+		}
+		return io.NopCloser(rd), nil
+	}
+	body, err := getBody()
+	return body, getBody, err
+}
+
+// idleTimeout cancels the attempt context if it is not touched within the timeout.
+type idleTimeout struct {
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut atomic.Bool
+}
+
+func newIdleTimeout(ctx context.Context, timeout time.Duration) (context.Context, *idleTimeout, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	it := &idleTimeout{timeout: timeout}
+	it.timer = time.AfterFunc(timeout, func() {
+		it.timedOut.Store(true)
+		cancel()
+	})
+	return ctx, it, func() {
+		it.timer.Stop()
+		cancel()
+	}
+}
+
+func (it *idleTimeout) touch() {
+	it.timer.Reset(it.timeout)
+}
+
+// mapErr reports a cancellation caused by the idle timeout as a deadline being exceeded,
+// so that it is retried like any other attempt timeout.
+func (it *idleTimeout) mapErr(err error) error {
+	if it == nil {
+		return err
+	}
+	if err != nil && it.timedOut.Load() && errors.Is(err, context.Canceled) {
+		return fmt.Errorf("idle timeout after %s: %w", it.timeout, context.DeadlineExceeded)
+	}
+	return err
+}
+
+// streamReader records any error reading the response body, and touches the idle timeout
+// whenever a read makes progress.
+type streamReader struct {
+	r     io.Reader
+	touch func()
+	err   error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.touch()
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.err = err
+	}
+	return n, err
+}
+
+// streamBody passes the response body to the stream handler. Errors reading the body are
+// retried, errors from the handler itself are not.
+func (r Request) streamBody(res *http.Response, it *idleTimeout) error {
+	sr := &streamReader{r: res.Body, touch: it.touch}
+	err := r.stream(sr, res.Header)
+	switch {
+	case sr.err != nil:
+		return fmt.Errorf("stream: %w", it.mapErr(sr.err))
+	case err != nil:
+		return backoff.Permanent(fmt.Errorf("stream: %w", err))
+	}
+	return nil
+}
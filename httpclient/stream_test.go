@@ -0,0 +1,135 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestClient_Call_BodyReader(t *testing.T) {
+	ctx := testcontext.Background()
+
+	var mu sync.Mutex
+	var bodies []string
+	var lengths []int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		assert.Check(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(b))
+		lengths = append(lengths, r.ContentLength)
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := New(Config{
+		BaseURL: server.URL,
+		Timeout: 5 * time.Second,
+	})
+
+	t.Run("replayed on retry", func(t *testing.T) {
+		factoryCalls := 0
+		err := client.Call(ctx, NewRequest("PUT", "/upload",
+			BodyReader(func() (io.Reader, error) {
+				factoryCalls++
+				return strings.NewReader("streamed body"), nil
+			}),
+			ContentLength(13),
+		))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(factoryCalls, 2))
+		assert.Check(t, cmp.DeepEqual(bodies, []string{"streamed body", "streamed body"}))
+		assert.Check(t, cmp.DeepEqual(lengths, []int64{13, 13}))
+	})
+
+	t.Run("factory errors are not retried", func(t *testing.T) {
+		factoryCalls := 0
+		err := client.Call(ctx, NewRequest("PUT", "/upload",
+			BodyReader(func() (io.Reader, error) {
+				factoryCalls++
+				return nil, errors.New("no file")
+			}),
+		))
+		assert.Check(t, cmp.ErrorContains(err, "body reader: no file"))
+		assert.Check(t, cmp.Equal(factoryCalls, 1))
+	})
+
+	t.Run("not allowed with a body", func(t *testing.T) {
+		err := client.Call(ctx, NewRequest("PUT", "/upload",
+			RawBody([]byte("raw")),
+			BodyReader(func() (io.Reader, error) { return nil, nil }),
+		))
+		assert.Check(t, cmp.Error(err, "cannot have a body reader with a body or raw body"))
+	})
+}
+
+func TestClient_Call_StreamResponse(t *testing.T) {
+	ctx := testcontext.Background()
+
+	var attempts atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempt := attempts.Add(1)
+		_, _ = io.WriteString(w, "first half,")
+		w.(http.Flusher).Flush()
+		if attempt == 1 {
+			// stall for longer than the idle timeout
+			time.Sleep(500 * time.Millisecond)
+		}
+		for i := 0; i < 5; i++ {
+			// each write is within the idle timeout, even though the whole body is not
+			time.Sleep(50 * time.Millisecond)
+			_, _ = io.WriteString(w, ".")
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := New(Config{
+		BaseURL: server.URL,
+		Timeout: 5 * time.Second,
+	})
+
+	t.Run("retries a stalled body", func(t *testing.T) {
+		var got strings.Builder
+		calls := 0
+		err := client.Call(ctx, NewRequest("GET", "/download",
+			Timeout(200*time.Millisecond),
+			StreamResponse(func(body io.Reader, header http.Header) error {
+				calls++
+				got.Reset()
+				_, err := io.Copy(&got, body)
+				return err
+			}),
+		))
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(calls, 2))
+		assert.Check(t, cmp.Equal(got.String(), "first half,....."))
+	})
+
+	t.Run("handler errors are not retried", func(t *testing.T) {
+		attempts.Store(1)
+		calls := 0
+		err := client.Call(ctx, NewRequest("GET", "/download",
+			StreamResponse(func(body io.Reader, header http.Header) error {
+				calls++
+				return errors.New("disk full")
+			}),
+		))
+		assert.Check(t, cmp.ErrorContains(err, "stream: disk full"))
+		assert.Check(t, cmp.Equal(calls, 1))
+	})
+}
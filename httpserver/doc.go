@@ -4,5 +4,8 @@ Package httpserver contains helpers for creating zero-downtime REST APIs.
 There are tools for:
 - observability (both for requests and connection info)
 - health checks
+- TLS serving, with certificate reloading and client certificate verification
+- configurable timeouts, per-route timeout overrides, h2c and connection limits
+- zero downtime restarts, by inheriting listeners from systemd or a previous process
 */
 package httpserver
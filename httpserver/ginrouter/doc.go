@@ -1,5 +1,11 @@
 /*
 Package ginrouter provides a common base for configuring a Gin router instance, wiring in the
 standard o11y wrappers.
+
+It also provides optional middleware to protect a service:
+- rate limiting, by client IP, principal or route
+- request body size limits
+- per-route handler deadlines
+- load shedding, by in flight requests and latency, with priority classes
 */
 package ginrouter
@@ -0,0 +1,108 @@
+package ginrouter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/circleci/ex/o11y"
+)
+
+// MaxBodySize is a gin middleware that limits request bodies to n bytes. Requests that declare
+// a larger body are rejected with a 413. Bodies without a declared length fail to be read past
+// the limit, and if the handler does not write a response the request is rejected with a 413.
+func MaxBodySize(n int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if c.Request.ContentLength > n {
+			o11y.AddField(ctx, "body_too_large", true)
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+		c.Next()
+
+		for _, err := range c.Errors {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err.Err, &tooLarge) {
+				o11y.AddField(ctx, "body_too_large", true)
+				if !c.Writer.Written() {
+					c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+				}
+				return
+			}
+		}
+	}
+}
+
+// Deadline is a gin middleware that cancels the request context after the timeout. Handlers
+// should stop once the context is done. Anything they write after the deadline is discarded,
+// and unless they wrote a response before it the request fails with a 504.
+func Deadline(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		w := c.Writer
+		c.Writer = &deadlineWriter{ResponseWriter: w, ctx: ctx}
+		c.Next()
+		c.Writer = w
+
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+		o11y.AddField(ctx, "deadline_exceeded", true)
+		if !c.Writer.Written() {
+			c.AbortWithStatus(http.StatusGatewayTimeout)
+		}
+	}
+}
+
+// deadlineWriter discards anything written once the deadline has been exceeded, so that the
+// 504 is sent instead.
+type deadlineWriter struct {
+	gin.ResponseWriter
+	ctx context.Context
+}
+
+func (w *deadlineWriter) exceeded() bool {
+	return errors.Is(w.ctx.Err(), context.DeadlineExceeded)
+}
+
+func (w *deadlineWriter) WriteHeader(code int) {
+	if w.exceeded() {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *deadlineWriter) WriteHeaderNow() {
+	if w.exceeded() {
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	if w.exceeded() {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *deadlineWriter) WriteString(s string) (int, error) {
+	if w.exceeded() {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *deadlineWriter) Flush() {
+	if w.exceeded() {
+		return
+	}
+	w.ResponseWriter.Flush()
+}
@@ -0,0 +1,136 @@
+package ginrouter
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/internal/syncbuffer"
+	"github.com/circleci/ex/o11y"
+	"github.com/circleci/ex/o11y/otel"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	b := &syncbuffer.SyncBuffer{}
+	p, err := otel.New(otel.Config{Writer: b})
+	assert.NilError(t, err)
+	ctx := o11y.WithProvider(context.Background(), p)
+
+	r := Default(ctx, "test server")
+	r.POST("/read", MaxBodySize(8), func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		c.String(http.StatusOK, string(body))
+	})
+	r.POST("/bad-request", MaxBodySize(8), func(c *gin.Context) {
+		_, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			_ = c.Error(err)
+			c.String(http.StatusBadRequest, "bad body")
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	post := func(path, body string, contentLength int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.ContentLength = contentLength
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("small body", func(t *testing.T) {
+		w := post("/read", "12345678", 8)
+		assert.Check(t, cmp.Equal(w.Code, http.StatusOK))
+		assert.Check(t, cmp.Equal(w.Body.String(), "12345678"))
+	})
+
+	t.Run("declared large body", func(t *testing.T) {
+		w := post("/read", "123456789", 9)
+		assert.Check(t, cmp.Equal(w.Code, http.StatusRequestEntityTooLarge))
+	})
+
+	t.Run("undeclared large body", func(t *testing.T) {
+		w := post("/read", "123456789", -1)
+		assert.Check(t, cmp.Equal(w.Code, http.StatusRequestEntityTooLarge))
+	})
+
+	t.Run("handler response is kept", func(t *testing.T) {
+		w := post("/bad-request", "123456789", -1)
+		assert.Check(t, cmp.Equal(w.Code, http.StatusBadRequest))
+		assert.Check(t, cmp.Equal(w.Body.String(), "bad body"))
+	})
+
+	p.Close(ctx)
+	assert.Check(t, cmp.Contains(b.String(), "app.body_too_large=true"))
+}
+
+func TestDeadline(t *testing.T) {
+	b := &syncbuffer.SyncBuffer{}
+	p, err := otel.New(otel.Config{Writer: b})
+	assert.NilError(t, err)
+	ctx := o11y.WithProvider(context.Background(), p)
+
+	r := Default(ctx, "test server")
+	wait := func(c *gin.Context) {
+		select {
+		case <-c.Request.Context().Done():
+			c.Status(http.StatusInternalServerError)
+		case <-time.After(time.Second):
+			c.Status(http.StatusOK)
+		}
+	}
+	r.GET("/short", Deadline(10*time.Millisecond), wait)
+	r.GET("/long", Deadline(5*time.Second), wait)
+	r.GET("/writes-when-done", Deadline(10*time.Millisecond), func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "cancelled"})
+	})
+	r.GET("/written", Deadline(10*time.Millisecond), func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		<-c.Request.Context().Done()
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	t.Run("exceeded", func(t *testing.T) {
+		w := get("/short")
+		assert.Check(t, cmp.Equal(w.Code, http.StatusGatewayTimeout))
+	})
+
+	t.Run("writes after the deadline are discarded", func(t *testing.T) {
+		w := get("/writes-when-done")
+		assert.Check(t, cmp.Equal(w.Code, http.StatusGatewayTimeout))
+		assert.Check(t, cmp.Equal(w.Body.String(), ""))
+	})
+
+	t.Run("not exceeded", func(t *testing.T) {
+		w := get("/long")
+		assert.Check(t, cmp.Equal(w.Code, http.StatusOK))
+	})
+
+	t.Run("written response is kept", func(t *testing.T) {
+		w := get("/written")
+		assert.Check(t, cmp.Equal(w.Code, http.StatusOK))
+		assert.Check(t, cmp.Equal(w.Body.String(), "partial"))
+	})
+
+	p.Close(ctx)
+	assert.Check(t, cmp.Contains(b.String(), "app.deadline_exceeded=true"))
+}
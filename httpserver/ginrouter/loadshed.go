@@ -0,0 +1,189 @@
+package ginrouter
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/circleci/ex/o11y"
+)
+
+// Priority is the class of a request when shedding load. Priorities are ordered, with the
+// zero value being PriorityNormal.
+type Priority int
+
+const (
+	// PriorityLow requests are shed first, once three quarters of the limit is in use, so
+	// that there is room left for the others.
+	PriorityLow Priority = iota - 1
+	// PriorityNormal requests are shed once the limit is reached.
+	PriorityNormal
+	// PriorityCritical requests, such as health checks and admin requests, are never shed.
+	PriorityCritical
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityCritical:
+		return "critical"
+	default:
+		return "normal"
+	}
+}
+
+// DefaultPriority treats health check, debug and admin routes as critical, and everything
+// else as normal.
+func DefaultPriority(c *gin.Context) Priority {
+	path := c.FullPath()
+	for _, prefix := range []string{"/live", "/ready", "/health", "/debug/", "/admin/"} {
+		if strings.HasPrefix(path, prefix) {
+			return PriorityCritical
+		}
+	}
+	return PriorityNormal
+}
+
+type LoadShedConfig struct {
+	// Name is the name of the shedder in metrics.
+	Name string
+	// MaxInFlight is the most requests handled at once, the default is 100.
+	MaxInFlight int
+	// MaxLatency if set adapts the limit to the latency of the requests. Each request slower
+	// than this reduces the limit by a tenth, down to one request at a time, and each faster
+	// one increases it again, up to the MaxInFlight.
+	MaxLatency time.Duration
+	// Priority classifies requests, the default is DefaultPriority.
+	Priority func(c *gin.Context) Priority
+}
+
+// LoadShedder rejects requests with a 503 when there are too many in flight, or when they
+// are taking too long, so that the requests it does handle are served in good time.
+type LoadShedder struct {
+	name        string
+	maxInFlight float64
+	maxLatency  time.Duration
+	priority    func(c *gin.Context) Priority
+
+	mu       sync.Mutex
+	inFlight int
+	limit    float64
+	shed     int
+}
+
+func NewLoadShedder(cfg LoadShedConfig) *LoadShedder {
+	if cfg.MaxInFlight == 0 {
+		cfg.MaxInFlight = 100
+	}
+	if cfg.Priority == nil {
+		cfg.Priority = DefaultPriority
+	}
+	return &LoadShedder{
+		name:        cfg.Name,
+		maxInFlight: float64(cfg.MaxInFlight),
+		maxLatency:  cfg.MaxLatency,
+		priority:    cfg.Priority,
+		limit:       float64(cfg.MaxInFlight),
+	}
+}
+
+// Middleware is a gin middleware that sheds load. The decisions are added to the request span.
+func (l *LoadShedder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		priority := l.priority(c)
+		o11y.AddField(ctx, "shed_priority", priority.String())
+		if priority == PriorityCritical {
+			c.Next()
+			return
+		}
+
+		inFlight, limit, ok := l.acquire(priority)
+		o11y.AddField(ctx, "shed_in_flight", inFlight)
+		o11y.AddField(ctx, "shed_limit", limit)
+		o11y.AddField(ctx, "shed", !ok)
+		l.count(ctx, c, priority, ok)
+		if !ok {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			l.release(time.Since(start))
+		}()
+		c.Next()
+	}
+}
+
+func (l *LoadShedder) acquire(priority Priority) (inFlight int, limit int, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	allowed := l.limit
+	if priority == PriorityLow {
+		allowed *= 0.75
+	}
+	// always allow one request, so that the latency can recover
+	if l.inFlight > 0 && float64(l.inFlight) >= math.Floor(allowed) {
+		l.shed++
+		return l.inFlight, int(allowed), false
+	}
+	l.inFlight++
+	return l.inFlight, int(allowed), true
+}
+
+func (l *LoadShedder) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	if l.maxLatency == 0 {
+		return
+	}
+	if latency > l.maxLatency {
+		l.limit = max(1, l.limit*0.9)
+	} else {
+		l.limit = min(l.maxInFlight, l.limit+1/l.limit)
+	}
+}
+
+func (l *LoadShedder) count(ctx context.Context, c *gin.Context, priority Priority, ok bool) {
+	m := o11y.FromContext(ctx).MetricsProvider()
+	if m == nil {
+		return
+	}
+	result := "allowed"
+	if !ok {
+		result = "shed"
+	}
+	_ = m.Count("loadshed", 1, []string{
+		"loadshed.name:" + l.name,
+		"loadshed.priority:" + priority.String(),
+		"loadshed.result:" + result,
+		"http.route:" + c.FullPath(),
+	}, 1)
+}
+
+// MetricName returns the name for the metrics the shedder will produce. (satisfies MetricProducer)
+func (l *LoadShedder) MetricName() string {
+	return l.name + "-loadshed"
+}
+
+// Gauges returns a set of key value pairs representing gauge metrics. (satisfies MetricProducer)
+func (l *LoadShedder) Gauges(_ context.Context) map[string]float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return map[string]float64{
+		"in_flight":     float64(l.inFlight),
+		"limit":         l.limit,
+		"shed_requests": float64(l.shed),
+	}
+}
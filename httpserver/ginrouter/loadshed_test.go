@@ -0,0 +1,150 @@
+package ginrouter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+	"gotest.tools/v3/poll"
+
+	"github.com/circleci/ex/internal/syncbuffer"
+	"github.com/circleci/ex/o11y"
+	"github.com/circleci/ex/o11y/otel"
+)
+
+func TestLoadShedder(t *testing.T) {
+	b := &syncbuffer.SyncBuffer{}
+	p, err := otel.New(otel.Config{Writer: b})
+	assert.NilError(t, err)
+	ctx := o11y.WithProvider(context.Background(), p)
+
+	shedder := NewLoadShedder(LoadShedConfig{
+		Name:        "test",
+		MaxInFlight: 4,
+		Priority: func(c *gin.Context) Priority {
+			if c.FullPath() == "/batch" {
+				return PriorityLow
+			}
+			return DefaultPriority(c)
+		},
+	})
+
+	release := make(chan struct{})
+	r := Default(ctx, "test server")
+	r.Use(shedder.Middleware())
+	ok := func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	}
+	r.GET("/slow", func(c *gin.Context) {
+		<-release
+		c.Status(http.StatusOK)
+	})
+	r.GET("/fast", ok)
+	r.GET("/batch", ok)
+	r.GET("/live", ok)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	waitForInFlight := func(t *testing.T, n float64) {
+		t.Helper()
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			if got := shedder.Gauges(ctx)["in_flight"]; got != n {
+				return poll.Continue("%v in flight", got)
+			}
+			return poll.Success()
+		})
+	}
+
+	var wg sync.WaitGroup
+	slow := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Check(t, cmp.Equal(get("/slow").Code, http.StatusOK))
+		}()
+	}
+
+	t.Run("low priority is shed first", func(t *testing.T) {
+		slow()
+		slow()
+		slow()
+		waitForInFlight(t, 3)
+
+		w := get("/batch")
+		assert.Check(t, cmp.Equal(w.Code, http.StatusServiceUnavailable))
+		assert.Check(t, cmp.Equal(w.Header().Get("Retry-After"), "1"))
+		assert.Check(t, cmp.Equal(get("/fast").Code, http.StatusOK))
+	})
+
+	t.Run("normal priority is shed at the limit", func(t *testing.T) {
+		slow()
+		waitForInFlight(t, 4)
+
+		assert.Check(t, cmp.Equal(get("/fast").Code, http.StatusServiceUnavailable))
+	})
+
+	t.Run("critical is never shed", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(get("/live").Code, http.StatusOK))
+	})
+
+	close(release)
+	wg.Wait()
+	t.Run("recovers", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(get("/batch").Code, http.StatusOK))
+		assert.Check(t, cmp.Equal(shedder.Gauges(ctx)["shed_requests"], float64(2)))
+	})
+
+	p.Close(ctx)
+	assert.Check(t, cmp.Contains(b.String(), "app.shed=true"))
+	assert.Check(t, cmp.Contains(b.String(), "app.shed_priority=critical"))
+}
+
+func TestPriority(t *testing.T) {
+	var zero Priority
+	assert.Check(t, cmp.Equal(zero, PriorityNormal))
+	assert.Check(t, PriorityLow < PriorityNormal)
+	assert.Check(t, PriorityNormal < PriorityCritical)
+}
+
+func TestLoadShedder_Latency(t *testing.T) {
+	l := NewLoadShedder(LoadShedConfig{
+		MaxInFlight: 10,
+		MaxLatency:  100 * time.Millisecond,
+	})
+	limit := func() float64 {
+		return l.Gauges(context.Background())["limit"]
+	}
+
+	for range 30 {
+		_, _, ok := l.acquire(PriorityNormal)
+		assert.Assert(t, ok)
+		l.release(time.Second)
+	}
+	assert.Check(t, cmp.Equal(limit(), float64(1)))
+
+	t.Run("one request is always allowed", func(t *testing.T) {
+		_, _, ok := l.acquire(PriorityLow)
+		assert.Check(t, ok)
+		_, _, ok = l.acquire(PriorityNormal)
+		assert.Check(t, !ok)
+		l.release(time.Millisecond)
+	})
+
+	t.Run("limit recovers", func(t *testing.T) {
+		for range 100 {
+			_, _, ok := l.acquire(PriorityNormal)
+			assert.Assert(t, ok)
+			l.release(time.Millisecond)
+		}
+		assert.Check(t, cmp.Equal(limit(), float64(10)))
+	})
+}
@@ -0,0 +1,177 @@
+package ginrouter
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/circleci/ex/o11y"
+)
+
+// RateLimitConfig configures a token bucket rate limit. Each key has its own bucket, which
+// holds up to Burst tokens and is refilled at Rate tokens a second. A request takes a token,
+// and is rejected with a 429 if there are none.
+type RateLimitConfig struct {
+	// Name identifies the limit in o11y and in the store, so it must be unique per limit.
+	Name string
+	// Rate is the number of requests allowed a second, on average.
+	Rate float64
+	// Burst is the number of requests allowed at once, the default is the Rate rounded up.
+	Burst int
+
+	// Key chooses the bucket for a request, the default is ByClientIP.
+	Key RateLimitKey
+	// Store holds the buckets, the default is a store in this process. Use a shared store,
+	// such as the redis one, to limit requests across replicas.
+	Store RateLimitStore
+}
+
+// RateLimitStore holds the token buckets for the rate limits.
+type RateLimitStore interface {
+	// Take takes a token from the bucket for the key, which holds up to burst tokens and is
+	// refilled at rate tokens a second. If there are none, it returns how long until there
+	// will be one.
+	Take(ctx context.Context, key string, rate float64, burst int) (retryAfter time.Duration, err error)
+}
+
+// RateLimitKey returns the key of the bucket a request takes a token from.
+type RateLimitKey func(c *gin.Context) string
+
+// ByClientIP limits each client IP address.
+func ByClientIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// ByRoute limits all the requests to each route.
+func ByRoute(c *gin.Context) string {
+	return c.Request.Method + " " + c.FullPath()
+}
+
+// ByPrincipal limits each authenticated principal, which an earlier middleware sets in the
+// gin context with the key. Unauthenticated requests are limited by client IP.
+func ByPrincipal(key string) RateLimitKey {
+	return func(c *gin.Context) string {
+		if p := c.GetString(key); p != "" {
+			return "principal:" + p
+		}
+		return "ip:" + c.ClientIP()
+	}
+}
+
+// RateLimit is a gin middleware that rejects requests over the rate limit with a 429, and a
+// Retry-After header. If the store fails the request is allowed. It panics if the Rate is not
+// positive or the Burst is negative, as the limit would never allow a request.
+func RateLimit(cfg RateLimitConfig) gin.HandlerFunc {
+	if cfg.Rate <= 0 {
+		panic("ginrouter: rate limit " + strconv.Quote(cfg.Name) + ": Rate must be positive")
+	}
+	if cfg.Burst == 0 {
+		cfg.Burst = int(math.Ceil(cfg.Rate))
+	}
+	if cfg.Burst < 1 {
+		panic("ginrouter: rate limit " + strconv.Quote(cfg.Name) + ": Burst must be at least 1")
+	}
+	if cfg.Key == nil {
+		cfg.Key = ByClientIP
+	}
+	if cfg.Store == nil {
+		cfg.Store = newMemoryStore(time.Now)
+	}
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		retryAfter, err := cfg.Store.Take(ctx, cfg.Name+":"+cfg.Key(c), cfg.Rate, cfg.Burst)
+
+		result := "allowed"
+		switch {
+		case err != nil:
+			result = "error"
+			o11y.AddField(ctx, "ratelimit_error", err)
+		case retryAfter > 0:
+			result = "limited"
+		}
+		o11y.AddField(ctx, "ratelimit_name", cfg.Name)
+		o11y.AddField(ctx, "ratelimit_result", result)
+
+		if m := o11y.FromContext(ctx).MetricsProvider(); m != nil {
+			_ = m.Count("ratelimit", 1, []string{
+				"ratelimit.name:" + cfg.Name,
+				"ratelimit.result:" + result,
+				"http.route:" + c.FullPath(),
+			}, 1)
+		}
+
+		if result == "limited" {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
+
+// memoryStore holds the token buckets in this process.
+type memoryStore struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	sweptAt   time.Time
+	fullAfter time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+func newMemoryStore(now func() time.Time) *memoryStore {
+	return &memoryStore{
+		now:     now,
+		buckets: make(map[string]*bucket),
+		sweptAt: now(),
+	}
+}
+
+func (s *memoryStore) Take(_ context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	fullAfter := time.Duration(float64(burst) / rate * float64(time.Second))
+	s.fullAfter = max(s.fullAfter, fullAfter)
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), at: now}
+		s.buckets[key] = b
+	}
+	b.tokens = min(float64(burst), b.tokens+now.Sub(b.at).Seconds()*rate)
+	b.at = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	return 0, nil
+}
+
+// sweep removes the buckets that have refilled, since they are the same as new ones. It is
+// done at most once a minute, or however long the slowest bucket takes to refill.
+func (s *memoryStore) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < max(time.Minute, s.fullAfter) {
+		return
+	}
+	s.sweptAt = now
+	for key, b := range s.buckets {
+		if now.Sub(b.at) >= s.fullAfter {
+			delete(s.buckets, key)
+		}
+	}
+}
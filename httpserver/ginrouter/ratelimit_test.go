@@ -0,0 +1,185 @@
+package ginrouter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/internal/syncbuffer"
+	"github.com/circleci/ex/o11y"
+	"github.com/circleci/ex/o11y/otel"
+	"github.com/circleci/ex/testing/fakemetrics"
+)
+
+func TestRateLimit(t *testing.T) {
+	b := &syncbuffer.SyncBuffer{}
+	m := &fakemetrics.Provider{}
+	p, err := otel.New(otel.Config{
+		Metrics: m,
+		Writer:  b,
+	})
+	assert.NilError(t, err)
+	ctx := o11y.WithProvider(context.Background(), p)
+
+	r := Default(ctx, "test server")
+	ok := func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	}
+	r.GET("/ip", RateLimit(RateLimitConfig{Name: "ip", Rate: 1, Burst: 2}), ok)
+	r.GET("/route", RateLimit(RateLimitConfig{Name: "route", Rate: 0.1, Key: ByRoute}), ok)
+	r.GET("/principal",
+		func(c *gin.Context) {
+			c.Set("principal", c.GetHeader("X-Principal"))
+		},
+		RateLimit(RateLimitConfig{Name: "principal", Rate: 0.5, Key: ByPrincipal("principal")}),
+		ok,
+	)
+	r.GET("/failing", RateLimit(RateLimitConfig{Name: "failing", Rate: 1, Store: failingStore{}}), ok)
+
+	get := func(path, ip, principal string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-Principal", principal)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	statuses := func(path, ip, principal string, n int) []int {
+		var codes []int
+		for range n {
+			codes = append(codes, get(path, ip, principal).Code)
+		}
+		return codes
+	}
+
+	t.Run("by client ip", func(t *testing.T) {
+		assert.Check(t, cmp.DeepEqual(statuses("/ip", "10.0.0.1", "", 3), []int{200, 200, 429}))
+		assert.Check(t, cmp.DeepEqual(statuses("/ip", "10.0.0.2", "", 1), []int{200}))
+
+		w := get("/ip", "10.0.0.1", "")
+		assert.Check(t, cmp.Equal(w.Code, http.StatusTooManyRequests))
+		assert.Check(t, cmp.Equal(w.Header().Get("Retry-After"), "1"))
+	})
+
+	t.Run("by route", func(t *testing.T) {
+		assert.Check(t, cmp.DeepEqual(statuses("/route", "10.0.0.3", "", 1), []int{200}))
+		w := get("/route", "10.0.0.4", "")
+		assert.Check(t, cmp.Equal(w.Code, http.StatusTooManyRequests))
+		assert.Check(t, cmp.Equal(w.Header().Get("Retry-After"), "10"))
+	})
+
+	t.Run("by principal", func(t *testing.T) {
+		assert.Check(t, cmp.DeepEqual(statuses("/principal", "10.0.0.5", "alice", 2), []int{200, 429}))
+		// the same principal from another address shares the bucket
+		assert.Check(t, cmp.DeepEqual(statuses("/principal", "10.0.0.6", "alice", 1), []int{429}))
+		assert.Check(t, cmp.DeepEqual(statuses("/principal", "10.0.0.6", "bob", 1), []int{200}))
+		// anonymous requests are limited by address
+		assert.Check(t, cmp.DeepEqual(statuses("/principal", "10.0.0.6", "", 2), []int{200, 429}))
+	})
+
+	t.Run("store errors allow requests", func(t *testing.T) {
+		assert.Check(t, cmp.DeepEqual(statuses("/failing", "10.0.0.7", "", 2), []int{200, 200}))
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		count := func(name, result string) int {
+			n := 0
+			for _, c := range m.Calls() {
+				if c.Name == "ratelimit" && slices.Contains(c.Tags, "ratelimit.name:"+name) &&
+					slices.Contains(c.Tags, "ratelimit.result:"+result) {
+					n++
+				}
+			}
+			return n
+		}
+		assert.Check(t, cmp.Equal(count("ip", "allowed"), 3))
+		assert.Check(t, cmp.Equal(count("ip", "limited"), 2))
+		assert.Check(t, cmp.Equal(count("failing", "error"), 2))
+	})
+
+	t.Run("span", func(t *testing.T) {
+		p.Close(ctx)
+		assert.Check(t, cmp.Contains(b.String(), "app.ratelimit_result=limited"))
+		assert.Check(t, cmp.Contains(b.String(), "app.ratelimit_error=store is down"))
+	})
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, float64, int) (time.Duration, error) {
+	return 0, errors.New("store is down")
+}
+
+func TestRateLimit_InvalidConfig(t *testing.T) {
+	panics := func(cfg RateLimitConfig) (msg any) {
+		defer func() {
+			msg = recover()
+		}()
+		RateLimit(cfg)
+		return nil
+	}
+
+	t.Run("zero rate", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(panics(RateLimitConfig{Name: "zero"}),
+			`ginrouter: rate limit "zero": Rate must be positive`))
+	})
+
+	t.Run("negative rate", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(panics(RateLimitConfig{Name: "negative", Rate: -1}),
+			`ginrouter: rate limit "negative": Rate must be positive`))
+	})
+
+	t.Run("negative burst", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(panics(RateLimitConfig{Name: "burst", Rate: 1, Burst: -1}),
+			`ginrouter: rate limit "burst": Burst must be at least 1`))
+	})
+
+	t.Run("burst defaults from a fractional rate", func(t *testing.T) {
+		assert.Check(t, cmp.Nil(panics(RateLimitConfig{Name: "fraction", Rate: 0.1})))
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := newMemoryStore(func() time.Time { return now })
+
+	take := func() time.Duration {
+		t.Helper()
+		retryAfter, err := s.Take(ctx, "key", 2, 4)
+		assert.Assert(t, err)
+		return retryAfter
+	}
+
+	for range 4 {
+		assert.Check(t, cmp.Equal(take(), time.Duration(0)))
+	}
+	assert.Check(t, cmp.Equal(take(), 500*time.Millisecond))
+
+	now = now.Add(250 * time.Millisecond)
+	assert.Check(t, cmp.Equal(take(), 250*time.Millisecond))
+
+	now = now.Add(time.Second)
+	assert.Check(t, cmp.Equal(take(), time.Duration(0)))
+	assert.Check(t, cmp.Equal(take(), time.Duration(0)))
+	assert.Check(t, take() > 0)
+
+	t.Run("refilled buckets are removed", func(t *testing.T) {
+		_, err := s.Take(ctx, "other", 2, 4)
+		assert.Assert(t, err)
+		assert.Check(t, cmp.Len(s.buckets, 2))
+
+		now = now.Add(time.Minute)
+		_, err = s.Take(ctx, "other", 2, 4)
+		assert.Assert(t, err)
+		assert.Check(t, cmp.Len(s.buckets, 1))
+	})
+}
@@ -0,0 +1,198 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// the systemd socket activation variables, see sd_listen_fds(3)
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+	listenFDsStart   = 3
+
+	// the variables set by Handoff, which name the inherited descriptors explicitly
+	envHandoffFDs     = "HTTPSERVER_LISTEN_FDS"
+	envHandoffFDNames = "HTTPSERVER_LISTEN_FDNAMES"
+)
+
+// inherited holds the listening sockets passed to this process that are yet to be claimed
+// by a server.
+var inherited struct {
+	mu        sync.Mutex
+	listeners []inheritedListener
+}
+
+type inheritedListener struct {
+	name   string
+	source string
+	ln     net.Listener
+}
+
+// inheritListener claims an inherited listener for the named server. One named after the
+// server is preferred, otherwise the first unclaimed unnamed one is used. It returns a nil
+// listener if there are none.
+func inheritListener(name string) (net.Listener, string, error) {
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+
+	// the variables are removed once read, so they are not passed on to any child processes
+	for _, fn := range []func() ([]inheritedListener, error){listenFDs, handoffFDs} {
+		lns, err := fn()
+		if err != nil {
+			return nil, "", err
+		}
+		inherited.listeners = append(inherited.listeners, lns...)
+	}
+	if len(inherited.listeners) == 0 {
+		return nil, "", nil
+	}
+
+	// a socket named for another server is left for it
+	i := slices.IndexFunc(inherited.listeners, func(l inheritedListener) bool { return l.name == name })
+	if i < 0 {
+		i = slices.IndexFunc(inherited.listeners, func(l inheritedListener) bool { return l.name == "" })
+	}
+	if i < 0 {
+		return nil, "", nil
+	}
+	l := inherited.listeners[i]
+	inherited.listeners = append(inherited.listeners[:i], inherited.listeners[i+1:]...)
+	return l.ln, l.source, nil
+}
+
+// listenFDs returns the listeners passed by systemd socket activation.
+func listenFDs() ([]inheritedListener, error) {
+	pid, fds, names := os.Getenv(envListenPID), os.Getenv(envListenFDs), os.Getenv(envListenFDNames)
+	if fds == "" {
+		return nil, nil
+	}
+	_ = os.Unsetenv(envListenPID)
+	_ = os.Unsetenv(envListenFDs)
+	_ = os.Unsetenv(envListenFDNames)
+
+	// the sockets were meant for another process
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil {
+		return nil, fmt.Errorf("inherit listener: invalid %s: %w", envListenFDs, err)
+	}
+	fdNums := make([]int, n)
+	for i := range fdNums {
+		fdNums[i] = listenFDsStart + i
+	}
+	lns, err := fileListeners("systemd", fdNums, names)
+	// without a FileDescriptorName systemd names a socket after its unit, or "unknown", which
+	// names no server, so those sockets are treated as unnamed
+	for i := range lns {
+		if strings.HasSuffix(lns[i].name, ".socket") || lns[i].name == "unknown" {
+			lns[i].name = ""
+		}
+	}
+	return lns, err
+}
+
+// handoffFDs returns the listeners passed by Handoff.
+func handoffFDs() ([]inheritedListener, error) {
+	fds, names := os.Getenv(envHandoffFDs), os.Getenv(envHandoffFDNames)
+	if fds == "" {
+		return nil, nil
+	}
+	_ = os.Unsetenv(envHandoffFDs)
+	_ = os.Unsetenv(envHandoffFDNames)
+
+	var fdNums []int
+	for _, s := range strings.Split(fds, ":") {
+		fd, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("inherit listener: invalid %s: %w", envHandoffFDs, err)
+		}
+		fdNums = append(fdNums, fd)
+	}
+	return fileListeners("handoff", fdNums, names)
+}
+
+func fileListeners(source string, fds []int, names string) (lns []inheritedListener, err error) {
+	nameList := strings.Split(names, ":")
+	for i, fd := range fds {
+		l := inheritedListener{source: source}
+		if names != "" && i < len(nameList) {
+			l.name = nameList[i]
+		}
+		f := os.NewFile(uintptr(fd), l.name)
+		if f == nil {
+			return nil, fmt.Errorf("inherit listener: invalid descriptor %d", fd)
+		}
+		// FileListener duplicates the descriptor, so the original is no longer needed
+		l.ln, err = net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherit listener: descriptor %d: %w", fd, err)
+		}
+		lns = append(lns, l)
+	}
+	return lns, nil
+}
+
+// Handoff passes the listening sockets of the servers to a new process, for zero downtime
+// restarts. The new process serves on them by creating its servers with Config.Inherit, with
+// the same names. Once the command has started, the servers here should be shut down by
+// cancelling the context passed to Serve, so they drain their in flight requests within the
+// ShutdownGrace while the new process accepts connections.
+//
+// The listeners are added to the command's ExtraFiles and its environment, so this must be
+// called before the command is started. The added files can be closed once it has started.
+func Handoff(cmd *exec.Cmd, servers ...*HTTPServer) (err error) {
+	var (
+		files []*os.File
+		fds   []string
+		names []string
+	)
+	defer func() {
+		if err != nil {
+			for _, f := range files {
+				_ = f.Close()
+			}
+		}
+	}()
+
+	for _, s := range servers {
+		fl, ok := s.listener.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("handoff %s: listener can not be passed on", s.name)
+		}
+		if strings.Contains(s.name, ":") {
+			return errors.New("handoff " + s.name + ": names must not contain ':'")
+		}
+		f, err := fl.File()
+		if err != nil {
+			return fmt.Errorf("handoff %s: %w", s.name, err)
+		}
+		files = append(files, f)
+		fds = append(fds, strconv.Itoa(listenFDsStart+len(cmd.ExtraFiles)+len(files)-1))
+		names = append(names, s.name)
+	}
+
+	for _, s := range servers {
+		// the socket file must remain for the new process once this one has shut down
+		if ul, ok := s.listener.Listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, files...)
+	cmd.Env = append(cmd.Environ(),
+		envHandoffFDs+"="+strings.Join(fds, ":"),
+		envHandoffFDNames+"="+strings.Join(names, ":"),
+	)
+	return nil
+}
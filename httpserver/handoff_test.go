@@ -0,0 +1,236 @@
+//go:build !windows
+
+package httpserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+	"gotest.tools/v3/poll"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+// TestHandoffChild is run as the new process by the handoff tests.
+func TestHandoffChild(t *testing.T) {
+	mode := os.Getenv("HTTPSERVER_TEST_CHILD")
+	if mode == "" {
+		t.Skip("only run as a child process")
+	}
+	if mode == "systemd" {
+		// systemd sets this once it has forked the process
+		t.Setenv(envListenPID, strconv.Itoa(os.Getpid()))
+	}
+
+	ctx := testcontext.Background()
+	srv, err := New(ctx, Config{
+		Name:    "handed",
+		Addr:    "localhost:0",
+		Inherit: true,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "child")
+		}),
+	})
+	assert.Assert(t, err)
+	// the parent kills this process once it is done
+	assert.Check(t, srv.Serve(ctx))
+}
+
+func startChild(t *testing.T, mode string, cmd *exec.Cmd) {
+	t.Helper()
+	cmd.Env = append(cmd.Environ(), "HTTPSERVER_TEST_CHILD="+mode)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	assert.Assert(t, cmd.Start())
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	for _, f := range cmd.ExtraFiles {
+		assert.Check(t, f.Close())
+	}
+}
+
+func childCommand() *exec.Cmd {
+	return exec.Command(os.Args[0], "-test.run=^TestHandoffChild$")
+}
+
+// waitForChild polls new connections to addr until they are served by the child.
+func waitForChild(t *testing.T, addr string) {
+	t.Helper()
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		c := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		body, err := fetch(c, "http://"+addr)
+		if body == "child" {
+			return poll.Success()
+		}
+		return poll.Continue("served by %q: %v", body, err)
+	})
+}
+
+func fetch(c *http.Client, url string) (string, error) {
+	res, err := c.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	return string(b), err
+}
+
+func TestHandoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(testcontext.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r := http.NewServeMux()
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "parent")
+	})
+	r.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "parent")
+	})
+
+	srv, err := New(ctx, Config{
+		Name:    "handed",
+		Addr:    "localhost:0",
+		Handler: r,
+	})
+	assert.Assert(t, err)
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return srv.Serve(ctx)
+	})
+
+	slow := make(chan string)
+	go func() {
+		body, err := fetch(http.DefaultClient, "http://"+srv.Addr()+"/slow")
+		assert.Check(t, err)
+		slow <- body
+	}()
+	<-started
+
+	cmd := childCommand()
+	assert.Assert(t, Handoff(cmd, srv))
+	startChild(t, "handoff", cmd)
+
+	// the parent stops accepting connections, and drains the one in flight
+	cancel()
+	waitForChild(t, srv.Addr())
+
+	close(release)
+	assert.Check(t, cmp.Equal(<-slow, "parent"))
+	assert.Check(t, g.Wait())
+
+	gauges := srv.MetricsProducer().Gauges(ctx)
+	assert.Check(t, cmp.Equal(gauges["active_connections"], float64(0)))
+	assert.Check(t, cmp.Equal(gauges["total_connections"], float64(1)))
+}
+
+func TestNew_InheritSystemd(t *testing.T) {
+	tests := []struct {
+		name    string
+		fdNames string
+	}{
+		{name: "file descriptor name", fdNames: "handed"},
+		// systemd names the socket after its unit if it has no FileDescriptorName
+		{name: "default name", fdNames: "handed-app.socket"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "localhost:0")
+			assert.Assert(t, err)
+			f, err := ln.(*net.TCPListener).File()
+			assert.Assert(t, err)
+
+			cmd := childCommand()
+			cmd.ExtraFiles = []*os.File{f}
+			cmd.Env = append(os.Environ(), envListenFDs+"=1", envListenFDNames+"="+tt.fdNames)
+			startChild(t, "systemd", cmd)
+			assert.Assert(t, ln.Close())
+
+			waitForChild(t, ln.Addr().String())
+		})
+	}
+}
+
+func TestNew_Inherit(t *testing.T) {
+	// listen returns a listener and a descriptor for it, which is owned by the inheriting server
+	listen := func(t *testing.T) (net.Listener, string) {
+		t.Helper()
+		ln, err := net.Listen("tcp", "localhost:0")
+		assert.Assert(t, err)
+		t.Cleanup(func() { _ = ln.Close() })
+		f, err := ln.(*net.TCPListener).File()
+		assert.Assert(t, err)
+		defer f.Close()
+		fd, err := syscall.Dup(int(f.Fd()))
+		assert.Assert(t, err)
+		return ln, strconv.Itoa(fd)
+	}
+	other, otherFD := listen(t)
+	wanted, wantedFD := listen(t)
+	unnamed, unnamedFD := listen(t)
+	t.Setenv(envHandoffFDs, otherFD+":"+wantedFD+":"+unnamedFD)
+	t.Setenv(envHandoffFDNames, "other:wanted")
+	// these are for another process, so are ignored
+	t.Setenv(envListenPID, "1")
+	t.Setenv(envListenFDs, "1")
+
+	inherit := func(t *testing.T, name string) *HTTPServer {
+		t.Helper()
+		return serve(t, Config{
+			Name:    name,
+			Addr:    "localhost:0",
+			Inherit: true,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, name)
+			}),
+		})
+	}
+
+	t.Run("by name", func(t *testing.T) {
+		srv := inherit(t, "wanted")
+		assert.Check(t, cmp.Equal(srv.Addr(), wanted.Addr().String()))
+		body, status := get(t, http.DefaultClient, srv.Addr(), "")
+		assert.Check(t, cmp.Equal(status, http.StatusOK))
+		assert.Check(t, cmp.Equal(body, "wanted"))
+
+		gauges := srv.MetricsProducer().Gauges(context.Background())
+		assert.Check(t, cmp.Equal(gauges["total_connections"], float64(1)))
+	})
+
+	t.Run("unnamed", func(t *testing.T) {
+		srv := inherit(t, "new")
+		assert.Check(t, cmp.Equal(srv.Addr(), unnamed.Addr().String()))
+	})
+
+	t.Run("named for another server", func(t *testing.T) {
+		srv := inherit(t, "another")
+		assert.Check(t, srv.Addr() != other.Addr().String())
+		assert.Check(t, srv.Addr() != wanted.Addr().String())
+		assert.Check(t, srv.Addr() != unnamed.Addr().String())
+	})
+
+	t.Run("other server", func(t *testing.T) {
+		srv := inherit(t, "other")
+		assert.Check(t, cmp.Equal(srv.Addr(), other.Addr().String()))
+	})
+
+	assert.Check(t, cmp.Equal(os.Getenv(envHandoffFDs), ""))
+	assert.Check(t, cmp.Equal(os.Getenv(envListenFDs), ""))
+}
@@ -2,5 +2,8 @@
 Package healthcheck contains a simple healthcheck handler. In addition to supporting the
 healthchecks that various other packages in ex produce, it also allows access to the Go
 runtime's standard pprof functionality.
+
+When created with Load the health checks include the system shutdown state, so the ready
+endpoint starts failing as soon as the service starts draining.
 */
 package healthcheck
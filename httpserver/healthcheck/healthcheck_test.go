@@ -13,6 +13,7 @@ import (
 	"gotest.tools/v3/assert/cmp"
 
 	"github.com/circleci/ex/system"
+	"github.com/circleci/ex/termination"
 	"github.com/circleci/ex/testing/testcontext"
 )
 
@@ -73,6 +74,25 @@ func TestAPI_NotReady(t *testing.T) {
 	assert.Check(t, cmp.Contains(body, `"status":"Unavailable"`))
 }
 
+func TestAPI_Draining(t *testing.T) {
+	state := termination.NewState()
+	baseurl := startAPI(t, state)
+
+	body, status := get(t, baseurl, "ready")
+	assert.Check(t, cmp.Equal(status, http.StatusOK))
+	assert.Check(t, cmp.Contains(body, `"status":"OK"`))
+
+	state.Drain()
+
+	body, status = get(t, baseurl, "ready")
+	assert.Check(t, cmp.Equal(status, http.StatusServiceUnavailable))
+	assert.Check(t, cmp.Contains(body, `"status":"Unavailable"`))
+
+	// draining must not cause the service to be restarted
+	_, status = get(t, baseurl, "live")
+	assert.Check(t, cmp.Equal(status, http.StatusOK))
+}
+
 func TestAPI_Debug(t *testing.T) {
 	baseurl := startAPI(t)
 
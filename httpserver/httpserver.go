@@ -2,6 +2,7 @@ package httpserver
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -14,9 +15,12 @@ import (
 )
 
 type HTTPServer struct {
+	name     string
 	listener *trackedListener
-	server   *http.Server
-	grace    time.Duration
+	// ln is the listener served, which wraps the tracked listener when serving TLS
+	ln     net.Listener
+	server *http.Server
+	grace  time.Duration
 }
 
 type Config struct {
@@ -33,6 +37,47 @@ type Config struct {
 
 	// ShutdownGrace is the period during which the server allows requests to be fully served.
 	ShutdownGrace time.Duration
+
+	// Inherit serves on a listening socket passed to the process, rather than listening on
+	// Addr, if there is one. Sockets are passed by systemd socket activation, or by Handoff
+	// from a previous process. The socket named after the server is used, otherwise the first
+	// unnamed one not already used by another server. Sockets named for other servers are
+	// left for them, so each should be named when there are several. With systemd the name is
+	// the FileDescriptorName of the socket unit, sockets without one are named after their
+	// unit and are treated as unnamed.
+	Inherit bool
+
+	// TLS if set serves TLS, optionally verifying client certificates.
+	TLS *TLSConfig
+	// H2C serves HTTP/2 without TLS, as well as HTTP/1.
+	H2C bool
+
+	// ReadTimeout is the time allowed to read a whole request, the default is 55 seconds.
+	ReadTimeout time.Duration
+	// WriteTimeout is the time allowed to write a response, the default is 55 seconds.
+	WriteTimeout time.Duration
+	// ReadHeaderTimeout is the time allowed to read the request headers, the default is
+	// 10 seconds.
+	ReadHeaderTimeout time.Duration
+	// IdleTimeout is how long an idle keep-alive connection is kept, the default is the
+	// ReadTimeout.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes limits the size of the request headers, the default is 1MB.
+	MaxHeaderBytes int
+	// RouteTimeouts override the ReadTimeout and WriteTimeout for matching requests.
+	RouteTimeouts []RouteTimeout
+
+	// MaxConnections if set limits the number of connections open at once. Connections beyond
+	// the limit are closed as soon as they are accepted, unless QueueConnections is set.
+	MaxConnections int
+	// QueueConnections holds connections beyond the MaxConnections until others are closed,
+	// rather than closing them.
+	QueueConnections bool
+
+	// DependsOn is the list of named system services that must be ready before the server
+	// starts serving, and that will only be stopped once the server has shut down.
+	// It is only used when the server is created with Load.
+	DependsOn []string
 }
 
 func New(ctx context.Context, cfg Config) (s *HTTPServer, err error) {
@@ -45,16 +90,43 @@ func New(ctx context.Context, cfg Config) (s *HTTPServer, err error) {
 	span.AddField("address", cfg.Addr)
 	span.AddField("network", cfg.Network)
 
-	ln, err := net.Listen(cfg.Network, cfg.Addr)
-	if err != nil {
-		return nil, err
+	var ln net.Listener
+	if cfg.Inherit {
+		var source string
+		ln, source, err = inheritListener(cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ln != nil {
+			span.AddField("inherited", source)
+		}
 	}
-
-	tr := &trackedListener{
-		Listener: ln,
-		name:     cfg.Name,
+	if ln == nil {
+		ln, err = net.Listen(cfg.Network, cfg.Addr)
+		if err != nil {
+			return nil, err
+		}
 	}
+
+	tr := newTrackedListener(ln, cfg.Name, cfg.MaxConnections, cfg.QueueConnections)
 	ln = tr
+	if cfg.MaxConnections > 0 {
+		span.AddField("max_connections", cfg.MaxConnections)
+		span.AddField("queue_connections", cfg.QueueConnections)
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		tlsConfig, err = cfg.TLS.load(tr.trackTLS)
+		if err != nil {
+			_ = tr.Close()
+			return nil, err
+		}
+		ln = tls.NewListener(tr, tlsConfig)
+		span.AddField("tls", true)
+		span.AddField("tls_certificates", len(cfg.TLS.Certificates))
+		span.AddField("tls_client_auth", tlsConfig.ClientAuth.String())
+	}
 
 	span.AddField("address", ln.Addr().String())
 
@@ -64,13 +136,42 @@ func New(ctx context.Context, cfg Config) (s *HTTPServer, err error) {
 	}
 	span.AddField("shutdown_grace", grace)
 
+	if cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = 55 * time.Second
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = 55 * time.Second
+	}
+	if cfg.ReadHeaderTimeout == 0 {
+		cfg.ReadHeaderTimeout = 10 * time.Second
+	}
+	span.AddField("read_timeout", cfg.ReadTimeout)
+	span.AddField("write_timeout", cfg.WriteTimeout)
+	span.AddField("read_header_timeout", cfg.ReadHeaderTimeout)
+	span.AddField("h2c", cfg.H2C)
+
+	var protocols *http.Protocols
+	if cfg.H2C {
+		protocols = &http.Protocols{}
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
 	return &HTTPServer{
+		name:     cfg.Name,
 		listener: tr,
+		ln:       ln,
 		server: &http.Server{
-			Addr:         cfg.Addr,
-			Handler:      cfg.Handler,
-			ReadTimeout:  55 * time.Second,
-			WriteTimeout: 55 * time.Second,
+			Addr:              cfg.Addr,
+			Handler:           routeTimeouts(cfg.RouteTimeouts, cfg.Handler),
+			ReadTimeout:       cfg.ReadTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+			Protocols:         protocols,
+			TLSConfig:         tlsConfig,
 		},
 		grace: grace,
 	}, nil
@@ -92,7 +193,7 @@ func (s *HTTPServer) Serve(ctx context.Context) error {
 	})
 
 	g.Go(func() error {
-		err := s.server.Serve(s.listener)
+		err := s.server.Serve(s.ln)
 		if !errors.Is(err, http.ErrServerClosed) {
 			return err
 		}
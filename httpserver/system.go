@@ -7,13 +7,24 @@ import (
 	"github.com/circleci/ex/system"
 )
 
+// Load will create a new HTTP server and add it to the provided System as a named service
+// using the server name. The server will only start serving once the services named in
+// cfg.DependsOn are ready, and will be shut down before them.
 func Load(ctx context.Context, cfg Config, sys *system.System) (*HTTPServer, error) {
 	server, err := New(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("error starting %q server", cfg.Name)
 	}
 
-	sys.AddService(server.Serve)
+	sys.AddNamedService(system.Service{
+		Name:      cfg.Name,
+		DependsOn: cfg.DependsOn,
+		Run: func(ctx context.Context, ready func()) error {
+			// the listener is already open, so dependents can rely on this server straight away
+			ready()
+			return server.Serve(ctx)
+		},
+	})
 	sys.AddMetrics(server.MetricsProducer())
 	return server, nil
 }
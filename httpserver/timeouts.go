@@ -0,0 +1,54 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RouteTimeout overrides the server read and write timeouts for some requests, for instance
+// for long polling or streaming endpoints.
+type RouteTimeout struct {
+	// Path matches requests with this path, or with paths under it if it ends in a slash.
+	Path string
+	// ReadTimeout is the time allowed to read the request body, zero means no timeout.
+	ReadTimeout time.Duration
+	// WriteTimeout is the time allowed to write the response, zero means no timeout.
+	WriteTimeout time.Duration
+}
+
+func (rt RouteTimeout) matches(path string) bool {
+	if strings.HasSuffix(rt.Path, "/") {
+		return strings.HasPrefix(path, rt.Path)
+	}
+	return path == rt.Path
+}
+
+// routeTimeouts wraps the handler to reset the connection deadlines for matching requests.
+// The first matching route is used.
+func routeTimeouts(routes []RouteTimeout, next http.Handler) http.Handler {
+	if len(routes) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rt := range routes {
+			if !rt.matches(r.URL.Path) {
+				continue
+			}
+			now := time.Now()
+			rc := http.NewResponseController(w)
+			// errors mean the connection does not support deadlines, so the server ones remain
+			_ = rc.SetReadDeadline(deadline(now, rt.ReadTimeout))
+			_ = rc.SetWriteDeadline(deadline(now, rt.WriteTimeout))
+			break
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func deadline(now time.Time, timeout time.Duration) time.Time {
+	if timeout == 0 {
+		return time.Time{}
+	}
+	return now.Add(timeout)
+}
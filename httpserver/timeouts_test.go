@@ -0,0 +1,125 @@
+package httpserver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestNew_Timeouts(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		_, _ = io.WriteString(w, r.Proto)
+	})
+	srv := serve(t, Config{
+		Name:         "timeouts server",
+		Addr:         "localhost:0",
+		Handler:      slow,
+		WriteTimeout: 100 * time.Millisecond,
+		RouteTimeouts: []RouteTimeout{
+			{Path: "/stream/", WriteTimeout: time.Second},
+			{Path: "/poll"},
+		},
+		MaxHeaderBytes: 1024,
+		H2C:            true,
+	})
+
+	get := func(path string, header http.Header, protocols *http.Protocols) (string, int, error) {
+		c := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+		defer c.CloseIdleConnections()
+		req, err := http.NewRequest("GET", "http://"+srv.Addr()+path, nil)
+		assert.Assert(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		res, err := c.Do(req)
+		if err != nil {
+			return "", 0, err
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		return string(b), res.StatusCode, err
+	}
+
+	t.Run("write timeout", func(t *testing.T) {
+		_, _, err := get("/slow", nil, nil)
+		assert.Check(t, err != nil)
+	})
+
+	t.Run("route timeouts", func(t *testing.T) {
+		for _, path := range []string{"/stream/events", "/poll"} {
+			body, status, err := get(path, nil, nil)
+			assert.Check(t, err)
+			assert.Check(t, cmp.Equal(status, http.StatusOK))
+			assert.Check(t, cmp.Equal(body, "HTTP/1.1"))
+		}
+		_, _, err := get("/poll/other", nil, nil)
+		assert.Check(t, err != nil)
+	})
+
+	t.Run("max header bytes", func(t *testing.T) {
+		// the server closes the connection without reading the request, which may reset it
+		_, status, err := get("/poll", http.Header{"X-Big": {strings.Repeat("a", 8192)}}, nil)
+		if err == nil {
+			assert.Check(t, cmp.Equal(status, http.StatusRequestHeaderFieldsTooLarge))
+		}
+		_, status, err = get("/poll", http.Header{"X-Small": {strings.Repeat("a", 512)}}, nil)
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(status, http.StatusOK))
+	})
+
+	t.Run("h2c", func(t *testing.T) {
+		protocols := &http.Protocols{}
+		protocols.SetUnencryptedHTTP2(true)
+		body, _, err := get("/poll", nil, protocols)
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(body, "HTTP/2.0"))
+	})
+}
+
+func TestRouteTimeout_matches(t *testing.T) {
+	tests := []struct {
+		route string
+		path  string
+		want  bool
+	}{
+		{route: "/poll", path: "/poll", want: true},
+		{route: "/poll", path: "/poll/", want: false},
+		{route: "/poll", path: "/polling", want: false},
+		{route: "/stream/", path: "/stream/", want: true},
+		{route: "/stream/", path: "/stream/events", want: true},
+		{route: "/stream/", path: "/stream", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route+" "+tt.path, func(t *testing.T) {
+			assert.Check(t, cmp.Equal(RouteTimeout{Path: tt.route}.matches(tt.path), tt.want))
+		})
+	}
+}
+
+func serve(t *testing.T, cfg Config) *HTTPServer {
+	t.Helper()
+	ctx, cancel := context.WithCancel(testcontext.Background())
+
+	srv, err := New(ctx, cfg)
+	assert.Assert(t, err)
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return srv.Serve(ctx)
+	})
+	t.Cleanup(func() {
+		cancel()
+		assert.Check(t, g.Wait())
+	})
+	return srv
+}
@@ -0,0 +1,162 @@
+package httpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/circleci/ex/rootcerts"
+)
+
+// TLSConfig configures the server to serve TLS.
+type TLSConfig struct {
+	// Certificates are the certificate and key files to serve. If there is more than one, the
+	// certificate is chosen by SNI, with the first used when none match.
+	Certificates []CertificateFiles
+	// ReloadInterval is how often the certificate files are checked for changes, the default
+	// is 10 seconds. Changed files are reloaded without restarting the server, and if they
+	// fail to load the previous certificate continues to be served.
+	ReloadInterval time.Duration
+
+	// ClientAuth is the policy for client certificates, the default is not to request them.
+	ClientAuth tls.ClientAuthType
+	// ClientCAs is the pool that client certificates are verified against, the default is
+	// the rootcerts server pool.
+	ClientCAs *x509.CertPool
+
+	// MinVersion is the minimum TLS version accepted, the default is TLS 1.2.
+	MinVersion uint16
+	// CipherSuites optionally restricts the TLS 1.2 cipher suites, see tls.Config.
+	CipherSuites []uint16
+}
+
+// CertificateFiles is a PEM encoded certificate chain and private key.
+type CertificateFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// load loads the certificates, and returns the config to serve with. The handshake func is
+// called with the underlying connection once the handshake has been verified.
+func (c *TLSConfig) load(handshake func(net.Conn, tls.ConnectionState)) (*tls.Config, error) {
+	if len(c.Certificates) == 0 {
+		return nil, errors.New("tls: no certificates")
+	}
+	interval := c.ReloadInterval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	store := &certStore{interval: interval, now: time.Now}
+	for _, files := range c.Certificates {
+		cert := &reloadingCert{files: files}
+		if err := cert.load(); err != nil {
+			return nil, err
+		}
+		store.certs = append(store.certs, cert)
+	}
+	store.checkedAt = store.now()
+
+	cfg := &tls.Config{
+		GetCertificate: store.getCertificate,
+		ClientAuth:     c.ClientAuth,
+		ClientCAs:      c.ClientCAs,
+		MinVersion:     c.MinVersion,
+		CipherSuites:   c.CipherSuites,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	if cfg.ClientCAs == nil && cfg.ClientAuth >= tls.VerifyClientCertIfGiven {
+		cfg.ClientCAs = rootcerts.ServerCertPool()
+	}
+	// the connection is only known to the config for each client
+	base := cfg.Clone()
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		cc := base.Clone()
+		cc.VerifyConnection = func(state tls.ConnectionState) error {
+			handshake(hello.Conn, state)
+			return nil
+		}
+		return cc, nil
+	}
+	return cfg, nil
+}
+
+// certStore holds the served certificates, reloading them when their files change.
+type certStore struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	certs     []*reloadingCert
+	checkedAt time.Time
+}
+
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now := s.now(); now.Sub(s.checkedAt) >= s.interval {
+		s.checkedAt = now
+		for _, c := range s.certs {
+			c.reload()
+		}
+	}
+
+	for _, c := range s.certs {
+		if hello.SupportsCertificate(c.cert) == nil {
+			return c.cert, nil
+		}
+	}
+	return s.certs[0].cert, nil
+}
+
+type reloadingCert struct {
+	files    CertificateFiles
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+func (c *reloadingCert) load() error {
+	modified, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.files.CertFile, c.files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls: load %q: %w", c.files.CertFile, err)
+	}
+	c.cert = &cert
+	c.modified = modified
+	return nil
+}
+
+// reload loads the certificate again if either file has changed. If it fails the current
+// certificate is kept, and it is tried again the next time.
+func (c *reloadingCert) reload() {
+	modified, err := c.lastModified()
+	if err != nil || modified.Equal(c.modified) {
+		return
+	}
+	_ = c.load()
+}
+
+func (c *reloadingCert) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, f := range []string{c.files.CertFile, c.files.KeyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("tls: %w", err)
+		}
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	return last, nil
+}
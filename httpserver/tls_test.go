@@ -0,0 +1,220 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+	"gotest.tools/v3/poll"
+
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestNew_TLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(testcontext.Background())
+	defer cancel()
+
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	apple := ca.writeCert(t, dir, "apple.example.com", 1)
+	banana := ca.writeCert(t, dir, "banana.example.com", 1)
+
+	srv, err := New(ctx, Config{
+		Name: "tls server",
+		Addr: "localhost:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			subject := "anonymous"
+			if len(r.TLS.PeerCertificates) > 0 {
+				subject = r.TLS.PeerCertificates[0].Subject.CommonName
+			}
+			_, _ = io.WriteString(w, r.Proto+" "+subject)
+		}),
+		TLS: &TLSConfig{
+			Certificates:   []CertificateFiles{apple, banana},
+			ReloadInterval: time.Nanosecond,
+			ClientAuth:     tls.VerifyClientCertIfGiven,
+			ClientCAs:      ca.pool(),
+		},
+	})
+	assert.Assert(t, err)
+
+	g, ctx := errgroup.WithContext(ctx)
+	t.Cleanup(func() {
+		assert.Check(t, g.Wait())
+	})
+	g.Go(func() error {
+		return srv.Serve(ctx)
+	})
+
+	newClient := func(serverName string, certs ...tls.Certificate) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				ForceAttemptHTTP2: true,
+				TLSClientConfig: &tls.Config{
+					RootCAs:      ca.pool(),
+					ServerName:   serverName,
+					Certificates: certs,
+				},
+			},
+		}
+	}
+	get := func(t *testing.T, c *http.Client) (body string, state *tls.ConnectionState) {
+		t.Helper()
+		res, err := c.Get("https://" + srv.Addr())
+		assert.Assert(t, err)
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		assert.Assert(t, err)
+		return string(b), res.TLS
+	}
+
+	t.Run("sni", func(t *testing.T) {
+		for _, name := range []string{"apple.example.com", "banana.example.com"} {
+			c := newClient(name)
+			body, state := get(t, c)
+			c.CloseIdleConnections()
+			assert.Check(t, cmp.Equal(body, "HTTP/2.0 anonymous"))
+			assert.Check(t, cmp.Equal(state.PeerCertificates[0].Subject.CommonName, name))
+		}
+	})
+
+	t.Run("client certificate", func(t *testing.T) {
+		c := newClient("apple.example.com", ca.clientCert(t, "client.example.com"))
+		t.Cleanup(c.CloseIdleConnections)
+		body, _ := get(t, c)
+		assert.Check(t, cmp.Equal(body, "HTTP/2.0 client.example.com"))
+
+		// wait for the connections from the other clients to be closed
+		var gauges map[string]float64
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			gauges = srv.MetricsProducer().Gauges(ctx)
+			if gauges["active_tls_connections"] == 1 {
+				return poll.Success()
+			}
+			return poll.Continue("active tls connections: %v", gauges["active_tls_connections"])
+		})
+		assert.Check(t, cmp.Equal(gauges["active_tls_connections.tls1.3"], float64(1)))
+		assert.Check(t, cmp.Equal(gauges["number_of_peer_subjects"], float64(1)))
+	})
+
+	t.Run("untrusted client certificate", func(t *testing.T) {
+		other := newTestCA(t)
+		c := newClient("apple.example.com", other.clientCert(t, "client.example.com"))
+		t.Cleanup(c.CloseIdleConnections)
+		_, err := c.Get("https://" + srv.Addr())
+		assert.Check(t, cmp.ErrorContains(err, "tls"))
+	})
+
+	t.Run("reloaded", func(t *testing.T) {
+		ca.writeCert(t, dir, "apple.example.com", 2)
+		c := newClient("apple.example.com")
+		t.Cleanup(c.CloseIdleConnections)
+		_, state := get(t, c)
+		assert.Check(t, cmp.Equal(state.PeerCertificates[0].SerialNumber.Int64(), int64(2)))
+	})
+
+	t.Run("bad files keep the current certificate", func(t *testing.T) {
+		assert.Assert(t, os.WriteFile(banana.CertFile, []byte("not a certificate"), 0600))
+		c := newClient("banana.example.com")
+		t.Cleanup(c.CloseIdleConnections)
+		_, state := get(t, c)
+		assert.Check(t, cmp.Equal(state.PeerCertificates[0].Subject.CommonName, "banana.example.com"))
+	})
+}
+
+func TestNew_TLSNoCertificates(t *testing.T) {
+	_, err := New(testcontext.Background(), Config{
+		Name: "tls server",
+		Addr: "localhost:0",
+		TLS:  &TLSConfig{},
+	})
+	assert.Check(t, cmp.ErrorContains(err, "tls: no certificates"))
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Assert(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Assert(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Assert(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func (ca *testCA) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Assert(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	assert.Assert(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Assert(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) writeCert(t *testing.T, dir, name string, serial int64) CertificateFiles {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, name, serial, x509.ExtKeyUsageServerAuth)
+	files := CertificateFiles{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	// write the key first, as a reload may happen between the writes
+	assert.Assert(t, os.WriteFile(files.KeyFile, keyPEM, 0600))
+	assert.Assert(t, os.WriteFile(files.CertFile, certPEM, 0600))
+	return files
+}
+
+func (ca *testCA) clientCert(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, name, 1, x509.ExtKeyUsageClientAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.Assert(t, err)
+	return cert
+}
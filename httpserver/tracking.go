@@ -2,8 +2,10 @@ package httpserver
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/url"
+	"strings"
 	"sync"
 )
 
@@ -17,22 +19,104 @@ type trackedListener struct {
 	accepted   int
 	activeConn int
 	remotes    map[string]int
+
+	// active TLS connections by version and cipher, which are kept at zero rather than removed
+	// so that the gauges drop to zero
+	activeTLS    int
+	tlsVersions  map[string]int
+	tlsCiphers   map[string]int
+	peerSubjects map[string]int
+
+	// slots limits the number of open connections, it is nil if there is no limit
+	slots     chan struct{}
+	queue     bool
+	rejected  int
+	queued    int
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newTrackedListener wraps ln, limiting it to maxConns open connections if maxConns is set.
+// Connections beyond the limit are held until there is room if queue is set, and otherwise
+// are closed.
+func newTrackedListener(ln net.Listener, name string, maxConns int, queue bool) *trackedListener {
+	l := &trackedListener{
+		Listener: ln,
+		name:     name,
+		done:     make(chan struct{}),
+	}
+	if maxConns > 0 {
+		l.slots = make(chan struct{}, maxConns)
+		l.queue = queue
+	}
+	return l
 }
 
 // Accept waits for and returns the next connection to the listener. It returns trackedConnection which
 // takes l as a field, so that the Close call can remove this connection from l's list of active connections.
 func (l *trackedListener) Accept() (net.Conn, error) {
-	con, err := l.Listener.Accept()
-	if err != nil {
-		return con, err
+	for {
+		con, err := l.Listener.Accept()
+		if err != nil {
+			return con, err
+		}
+		if !l.acquire(con) {
+			continue
+		}
+		tracked := &trackedConnection{
+			l:    l,
+			Conn: con,
+		}
+		l.trackConn(tracked, true)
+
+		return tracked, err
 	}
-	tracked := &trackedConnection{
-		l:    l,
-		Conn: con,
+}
+
+// acquire takes a slot for the connection if there is a connection limit. It reports false
+// if the connection was rejected, or the listener was closed while it was queued.
+func (l *trackedListener) acquire(con net.Conn) bool {
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	l.mu.Lock()
+	if !l.queue {
+		l.rejected++
+		l.mu.Unlock()
+		_ = con.Close()
+		return false
 	}
-	l.trackConn(tracked, true)
+	l.queued++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
 
-	return tracked, err
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-l.done:
+		_ = con.Close()
+		return false
+	}
+}
+
+// Close closes the listener, and any connections queued for a slot.
+func (l *trackedListener) Close() error {
+	l.closeOnce.Do(func() {
+		if l.done != nil {
+			close(l.done)
+		}
+	})
+	return l.Listener.Close()
 }
 
 // MetricName returns the name for the metrics the listener will produce. (satisfies MetricProducer)
@@ -62,7 +146,7 @@ func (l *trackedListener) Gauges(_ context.Context) map[string]float64 {
 			}
 		}
 	}
-	return map[string]float64{
+	gauges := map[string]float64{
 		"number_of_remotes":  float64(len(l.remotes)),
 		"total_connections":  float64(l.accepted),
 		"active_connections": float64(l.activeConn),
@@ -70,6 +154,54 @@ func (l *trackedListener) Gauges(_ context.Context) map[string]float64 {
 		"max_connections_per_remote": float64(max),
 		"min_connections_per_remote": float64(min),
 	}
+	if l.slots != nil {
+		gauges["max_connections"] = float64(cap(l.slots))
+		gauges["rejected_connections"] = float64(l.rejected)
+		gauges["queued_connections"] = float64(l.queued)
+	}
+	if l.tlsVersions != nil {
+		gauges["active_tls_connections"] = float64(l.activeTLS)
+		gauges["number_of_peer_subjects"] = float64(len(l.peerSubjects))
+		for version, n := range l.tlsVersions {
+			gauges["active_tls_connections."+version] = float64(n)
+		}
+		for cipher, n := range l.tlsCiphers {
+			gauges["active_tls_connections."+cipher] = float64(n)
+		}
+	}
+	return gauges
+}
+
+// trackTLS records the TLS details of a connection once its handshake has been verified.
+func (l *trackedListener) trackTLS(c net.Conn, state tls.ConnectionState) {
+	tracked, ok := c.(*trackedConnection)
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if tracked.tls != nil || tracked.closed {
+		return
+	}
+	if l.tlsVersions == nil {
+		l.tlsVersions = make(map[string]int)
+		l.tlsCiphers = make(map[string]int)
+		l.peerSubjects = make(map[string]int)
+	}
+	tracked.tls = &connTLS{
+		version: strings.ToLower(strings.ReplaceAll(tls.VersionName(state.Version), " ", "")),
+		cipher:  strings.ToLower(tls.CipherSuiteName(state.CipherSuite)),
+	}
+	if len(state.PeerCertificates) > 0 {
+		tracked.tls.peerSubject = state.PeerCertificates[0].Subject.String()
+	}
+	l.activeTLS++
+	l.tlsVersions[tracked.tls.version]++
+	l.tlsCiphers[tracked.tls.cipher]++
+	if tracked.tls.peerSubject != "" {
+		l.peerSubjects[tracked.tls.peerSubject]++
+	}
 }
 
 // trackConn adds or removes a connection from our tracking list.
@@ -86,11 +218,29 @@ func (l *trackedListener) trackConn(c *trackedConnection, add bool) {
 		l.activeConn++
 		l.remotes[host]++
 	} else {
+		if c.closed {
+			return
+		}
 		l.activeConn--
 		l.remotes[host]--
 		if l.remotes[host] == 0 {
 			delete(l.remotes, host)
 		}
+		c.closed = true
+		if l.slots != nil {
+			<-l.slots
+		}
+		if t := c.tls; t != nil {
+			l.activeTLS--
+			l.tlsVersions[t.version]--
+			l.tlsCiphers[t.cipher]--
+			if t.peerSubject != "" {
+				l.peerSubjects[t.peerSubject]--
+				if l.peerSubjects[t.peerSubject] == 0 {
+					delete(l.peerSubjects, t.peerSubject)
+				}
+			}
+		}
 	}
 }
 
@@ -100,6 +250,16 @@ type trackedConnection struct {
 	net.Conn
 
 	l *trackedListener
+
+	// the following are guarded by the listener mutex
+	tls    *connTLS
+	closed bool
+}
+
+type connTLS struct {
+	version     string
+	cipher      string
+	peerSubject string
 }
 
 // Close updates the trackedConnection and closes the underlying connection.
@@ -1,8 +1,11 @@
 package httpserver
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"testing"
 	"time"
@@ -137,3 +140,88 @@ func TestTrackedListenerName(t *testing.T) {
 	assert.Assert(t, err)
 	assert.Check(t, cmp.Equal(s.MetricsProducer().MetricName(), "test-server-listener"))
 }
+
+func TestTrackedListener_MaxConnections(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	waitForGauge := func(t *testing.T, s *HTTPServer, name string, want float64) {
+		t.Helper()
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			got := s.MetricsProducer().Gauges(context.Background())[name]
+			if got == want {
+				return poll.Success()
+			}
+			return poll.Continue("%s is %v", name, got)
+		})
+	}
+	request := func(conn net.Conn) (int, error) {
+		_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		if err != nil {
+			return 0, err
+		}
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return 0, err
+		}
+		return res.StatusCode, res.Body.Close()
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		s := serve(t, Config{
+			Name:           "limited",
+			Addr:           "localhost:0",
+			Handler:        handler,
+			MaxConnections: 1,
+		})
+
+		first, err := net.Dial("tcp", s.Addr())
+		assert.Assert(t, err)
+		t.Cleanup(func() { _ = first.Close() })
+		waitForGauge(t, s, "active_connections", 1)
+
+		second, err := net.Dial("tcp", s.Addr())
+		assert.Assert(t, err)
+		t.Cleanup(func() { _ = second.Close() })
+		_, err = request(second)
+		assert.Check(t, err != nil)
+		waitForGauge(t, s, "rejected_connections", 1)
+
+		status, err := request(first)
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(status, http.StatusNoContent))
+
+		gauges := s.MetricsProducer().Gauges(context.Background())
+		assert.Check(t, cmp.Equal(gauges["max_connections"], float64(1)))
+		assert.Check(t, cmp.Equal(gauges["total_connections"], float64(1)))
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		s := serve(t, Config{
+			Name:             "queued",
+			Addr:             "localhost:0",
+			Handler:          handler,
+			MaxConnections:   1,
+			QueueConnections: true,
+		})
+
+		first, err := net.Dial("tcp", s.Addr())
+		assert.Assert(t, err)
+		waitForGauge(t, s, "active_connections", 1)
+
+		second, err := net.Dial("tcp", s.Addr())
+		assert.Assert(t, err)
+		t.Cleanup(func() { _ = second.Close() })
+		waitForGauge(t, s, "queued_connections", 1)
+
+		assert.Check(t, first.Close())
+		status, err := request(second)
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(status, http.StatusNoContent))
+
+		waitForGauge(t, s, "queued_connections", 0)
+		gauges := s.MetricsProducer().Gauges(context.Background())
+		assert.Check(t, cmp.Equal(gauges["rejected_connections"], float64(0)))
+		assert.Check(t, cmp.Equal(gauges["total_connections"], float64(2)))
+	})
+}
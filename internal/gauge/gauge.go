@@ -0,0 +1,8 @@
+// Package gauge holds the tagged gauge value shared by the system package and the packages it
+// depends on, so that they can produce tagged gauges without an import cycle.
+package gauge
+
+type TaggedValue struct {
+	Val  float64
+	Tags []string
+}
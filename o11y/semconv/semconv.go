@@ -2,8 +2,13 @@ package semconv
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"io"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.30.0"
 )
 
 // DefaultErrorType will return the low cardinality error type for any recognised err.
@@ -19,3 +24,21 @@ func DefaultErrorType(err error) string {
 		return ""
 	}
 }
+
+// SetTLS sets the TLS attributes of a request served over TLS, including the subject of any
+// client certificate.
+func SetTLS(a map[attribute.Key]any, state *tls.ConnectionState) {
+	if state == nil {
+		return
+	}
+	a[semconv.TLSEstablishedKey] = state.HandshakeComplete
+	a[semconv.TLSProtocolNameKey] = "tls"
+	a[semconv.TLSProtocolVersionKey] = strings.TrimPrefix(tls.VersionName(state.Version), "TLS ")
+	a[semconv.TLSCipherKey] = tls.CipherSuiteName(state.CipherSuite)
+	if state.ServerName != "" {
+		a["tls.client.server_name"] = state.ServerName
+	}
+	if len(state.PeerCertificates) > 0 {
+		a[semconv.TLSClientSubjectKey] = state.PeerCertificates[0].Subject.String()
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	hc "github.com/circleci/ex/httpclient"
 	"github.com/circleci/ex/o11y"
+	exsemconv "github.com/circleci/ex/o11y/semconv"
 )
 
 type requestVals struct {
@@ -33,6 +34,7 @@ func semconvServerRequest(span o11y.Span, v requestVals) {
 	hc.SetString(as, semconv.ClientAddressKey, v.ClientIP)
 	hc.SetString(as, semconv.UserAgentOriginalKey, v.Req.Header.Get("User-Agent"))
 	hc.SetString(as, "http.request.header.referer", v.Req.Header.Get("Referer"))
+	exsemconv.SetTLS(as, v.Req.TLS)
 
 	for k, v := range as {
 		span.AddRawField(string(k), v)
@@ -9,7 +9,10 @@ import (
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"github.com/circleci/ex/o11y"
+	exsemconv "github.com/circleci/ex/o11y/semconv"
 	"github.com/circleci/ex/o11y/wrappers/baggage"
 )
 
@@ -36,6 +39,11 @@ func Middleware(provider o11y.Provider, name string, handler http.Handler) http.
 		// We expect consumers to override these fields if they have something better
 		span.AddRawField("name", fmt.Sprintf("http-server %s: %s %s", name, r.Method, r.URL.Path))
 		span.AddRawField("request.route", "unknown")
+		tlsAttrs := map[attribute.Key]any{}
+		exsemconv.SetTLS(tlsAttrs, r.TLS)
+		for k, v := range tlsAttrs {
+			span.AddRawField(string(k), v)
+		}
 
 		sw := &statusWriter{ResponseWriter: w}
 		handler.ServeHTTP(sw, r)
@@ -0,0 +1,28 @@
+/*
+Package outbox implements the transactional outbox pattern for publishing RabbitMQ messages
+from database transactions.
+
+Messages are enqueued into an outbox table using the same db.Querier as the rest of the
+transaction, so they are only published if the transaction commits. A Relay then publishes
+them via a rabbit.PublisherPool, and cleans up the published rows.
+
+	ob := outbox.New("")
+	err := txManager.WithTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if _, err := q.ExecContext(ctx, "INSERT INTO things ...", ...); err != nil {
+			return err
+		}
+		return ob.EnqueueJSON(ctx, q, outbox.Message{Key: thingID, RoutingKey: "thing.created"}, thing)
+	})
+
+Delivery is at-least-once, so consumers must tolerate duplicates. Messages with the same Key are
+published in the order of their ids, even with several relays running. The ids are allocated as
+messages are enqueued, but transactions can commit in a different order, so a message from a
+transaction that commits late can be published after later ones with the same Key. Messages
+with the same Key are only published in the order they were enqueued if the transactions
+enqueuing them do not overlap, for instance because they lock the row the Key refers to.
+Messages without a Key have no ordering guarantee.
+
+The outbox table is created by the SQL returned from Schema, which should be added to the
+service's migrations.
+*/
+package outbox
@@ -0,0 +1,111 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/circleci/ex/db"
+	"github.com/circleci/ex/o11y"
+	"github.com/circleci/ex/rabbit"
+)
+
+// DefaultTable is the name of the outbox table if none is given.
+const DefaultTable = "outbox"
+
+// Schema returns the SQL to create the outbox table and its index.
+func Schema(table string) string {
+	if table == "" {
+		table = DefaultTable
+	}
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %[1]s (
+	id bigserial PRIMARY KEY,
+	ordering_key text NOT NULL DEFAULT '',
+	exchange text NOT NULL DEFAULT '',
+	routing_key text NOT NULL,
+	content_type text NOT NULL DEFAULT '',
+	headers jsonb NOT NULL DEFAULT '{}',
+	body bytea NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	published_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS %[1]s_unpublished_idx ON %[1]s (id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS %[1]s_published_at_idx ON %[1]s (published_at) WHERE published_at IS NOT NULL;
+`, table)
+}
+
+// Message is a message to be published once the transaction it was enqueued in commits.
+type Message struct {
+	// Key orders messages, messages with the same key are published in the order they
+	// were enqueued. It is not sent with the message.
+	Key         string
+	Exchange    string
+	RoutingKey  string
+	ContentType string
+	Headers     map[string]string
+	Body        []byte
+}
+
+// Outbox enqueues messages into an outbox table.
+type Outbox struct {
+	table string
+}
+
+// New returns an Outbox using the given table, or DefaultTable if table is empty.
+// The table name is used in queries as is, so must not come from user input.
+func New(table string) *Outbox {
+	if table == "" {
+		table = DefaultTable
+	}
+	return &Outbox{table: table}
+}
+
+// Enqueue adds the message to the outbox. The querier should be the one passed to the
+// db.TxManager.WithTx function, so that the message is only published if the transaction commits.
+// The trace context of ctx is stored with the message, so the publish continues the trace.
+func (o *Outbox) Enqueue(ctx context.Context, q db.Querier, msg Message) (err error) {
+	ctx, span := o11y.StartSpan(ctx, "outbox: enqueue")
+	defer o11y.End(span, &err)
+	span.AddField("table", o.table)
+	span.AddField("key", msg.Key)
+	span.AddField("exchange", msg.Exchange)
+	span.AddField("routing_key", msg.RoutingKey)
+
+	headers := map[string]string{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	for k, v := range rabbit.InjectPropagation(ctx, nil) {
+		if s, ok := v.(string); ok {
+			headers[k] = s
+		}
+	}
+	h, err := json.Marshal(headers)
+	if err != nil {
+		return fmt.Errorf("marshal headers: %w", err)
+	}
+
+	// #nosec - the table name is not user input
+	query := fmt.Sprintf(`
+INSERT INTO %s (ordering_key, exchange, routing_key, content_type, headers, body)
+VALUES ($1, $2, $3, $4, $5, $6);
+`, o.table)
+	_, err = q.ExecContext(ctx, query,
+		msg.Key, msg.Exchange, msg.RoutingKey, msg.ContentType, string(h), msg.Body)
+	if err != nil {
+		return fmt.Errorf("enqueue: %w", err)
+	}
+	return nil
+}
+
+// EnqueueJSON marshals v as the body of the message, and enqueues it.
+func (o *Outbox) EnqueueJSON(ctx context.Context, q db.Querier, msg Message, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal body: %w", err)
+	}
+	msg.ContentType = rabbit.JSON
+	msg.Body = body
+	return o.Enqueue(ctx, q, msg)
+}
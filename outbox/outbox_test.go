@@ -0,0 +1,188 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/makasim/amqpextra/publisher"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/db"
+	"github.com/circleci/ex/testing/dbfixture"
+	"github.com/circleci/ex/testing/testcontext"
+	"github.com/circleci/ex/worker"
+)
+
+// TestRelay needs a database, and is skipped without one. It covers publishing in id order,
+// holding back keys that failed or are in flight in another relay, and cleaning up. Messages
+// from transactions that commit out of order are not guaranteed to be published in order,
+// so that is not tested.
+func TestRelay(t *testing.T) {
+	ctx := testcontext.Background()
+	fix := dbfixture.SetupDB(ctx, t, Schema(""), dbfixture.Connection{
+		Host:     "localhost:5432",
+		User:     "user",
+		Password: "password",
+	})
+
+	ob := New("")
+	pub := &fakePublisher{}
+	r := NewRelay(ob, fix.TX, pub, RelayConfig{BatchSize: 10})
+
+	enqueue := func(t *testing.T, msgs ...Message) {
+		t.Helper()
+		err := fix.TX.WithTx(ctx, func(ctx context.Context, q db.Querier) error {
+			for _, m := range msgs {
+				if err := ob.Enqueue(ctx, q, m); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.Assert(t, err)
+	}
+
+	t.Run("Rolled back messages are not published", func(t *testing.T) {
+		err := fix.TX.WithTx(ctx, func(ctx context.Context, q db.Querier) error {
+			err := ob.EnqueueJSON(ctx, q, Message{RoutingKey: "rolled-back"}, map[string]string{"a": "b"})
+			assert.Check(t, err)
+			return errors.New("roll back")
+		})
+		assert.Check(t, cmp.ErrorContains(err, "roll back"))
+
+		err = r.relay(ctx)
+		assert.Check(t, cmp.ErrorIs(err, worker.ErrShouldBackoff))
+		assert.Check(t, cmp.Len(pub.keys(), 0))
+	})
+
+	t.Run("Messages are published in order", func(t *testing.T) {
+		enqueue(t,
+			Message{Key: "a", RoutingKey: "a1", Body: []byte("a1")},
+			Message{Key: "b", RoutingKey: "b1", Body: []byte("b1")},
+			Message{Key: "a", RoutingKey: "a2", Body: []byte("a2")},
+		)
+		err := r.relay(ctx)
+		assert.Check(t, cmp.ErrorIs(err, worker.ErrShouldBackoff))
+		assert.Check(t, cmp.DeepEqual(pub.keys(), []string{"a1", "b1", "a2"}))
+		assert.Check(t, cmp.Equal(r.Gauges(ctx)["pending"], 0.0))
+		assert.Check(t, cmp.Equal(r.Gauges(ctx)["published"], 3.0))
+	})
+
+	t.Run("A failed message holds back later messages with the same key", func(t *testing.T) {
+		pub.reset()
+		pub.fail("c1")
+		enqueue(t,
+			Message{Key: "c", RoutingKey: "c1"},
+			Message{Key: "d", RoutingKey: "d1"},
+			Message{Key: "c", RoutingKey: "c2"},
+		)
+		err := r.relay(ctx)
+		assert.Check(t, cmp.ErrorIs(err, worker.ErrShouldBackoff))
+		assert.Check(t, cmp.DeepEqual(pub.keys(), []string{"d1"}))
+		assert.Check(t, cmp.Equal(r.Gauges(ctx)["pending"], 2.0))
+		assert.Check(t, r.Gauges(ctx)["lag_seconds"] > 0)
+
+		pub.fail()
+		err = r.relay(ctx)
+		assert.Check(t, cmp.ErrorIs(err, worker.ErrShouldBackoff))
+		assert.Check(t, cmp.DeepEqual(pub.keys(), []string{"d1", "c1", "c2"}))
+	})
+
+	t.Run("Keys in flight in another relay are held back", func(t *testing.T) {
+		pub.reset()
+		enqueue(t,
+			Message{Key: "e", RoutingKey: "e1"},
+			Message{Key: "f", RoutingKey: "f1"},
+			Message{Key: "e", RoutingKey: "e2"},
+		)
+		blocking := &blockingPublisher{publishing: make(chan struct{}), release: make(chan struct{})}
+		other := NewRelay(ob, fix.TX, blocking, RelayConfig{BatchSize: 1})
+		done := make(chan error)
+		go func() {
+			done <- other.relay(ctx)
+		}()
+		<-blocking.publishing
+
+		err := r.relay(ctx)
+		assert.Check(t, cmp.ErrorIs(err, worker.ErrShouldBackoff))
+		assert.Check(t, cmp.DeepEqual(pub.keys(), []string{"f1"}))
+
+		close(blocking.release)
+		assert.Check(t, <-done)
+		err = r.relay(ctx)
+		assert.Check(t, cmp.ErrorIs(err, worker.ErrShouldBackoff))
+		assert.Check(t, cmp.DeepEqual(pub.keys(), []string{"f1", "e2"}))
+	})
+
+	t.Run("Published messages are cleaned up", func(t *testing.T) {
+		r.cfg.Retention = time.Nanosecond
+		err := r.cleanup(ctx)
+		assert.Check(t, cmp.ErrorIs(err, worker.ErrShouldBackoff))
+		assert.Check(t, cmp.Equal(r.Gauges(ctx)["deleted"], 9.0))
+
+		var count int
+		err = fix.TX.NoTx().GetContext(ctx, &count, "SELECT count(*) FROM outbox")
+		assert.Check(t, err)
+		assert.Check(t, cmp.Equal(count, 0))
+	})
+}
+
+// blockingPublisher blocks publishing the first message until it is released.
+type blockingPublisher struct {
+	once       sync.Once
+	publishing chan struct{}
+	release    chan struct{}
+}
+
+func (p *blockingPublisher) Publish(context.Context, publisher.Message) error {
+	p.once.Do(func() {
+		close(p.publishing)
+		<-p.release
+	})
+	return nil
+}
+
+type fakePublisher struct {
+	mu       sync.Mutex
+	msgs     []publisher.Message
+	failKeys map[string]bool
+}
+
+func (p *fakePublisher) Publish(_ context.Context, msg publisher.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failKeys[msg.Key] {
+		return errors.New("publish failed")
+	}
+	p.msgs = append(p.msgs, msg)
+	return nil
+}
+
+func (p *fakePublisher) fail(keys ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failKeys = map[string]bool{}
+	for _, k := range keys {
+		p.failKeys[k] = true
+	}
+}
+
+func (p *fakePublisher) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = nil
+}
+
+func (p *fakePublisher) keys() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var keys []string
+	for _, m := range p.msgs {
+		keys = append(keys, m.Key)
+	}
+	return keys
+}
@@ -0,0 +1,319 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+	"github.com/makasim/amqpextra/publisher"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/circleci/ex/db"
+	"github.com/circleci/ex/o11y"
+	"github.com/circleci/ex/system"
+	"github.com/circleci/ex/worker"
+)
+
+// Publisher publishes a message, it is satisfied by *rabbit.PublisherPool.
+type Publisher interface {
+	Publish(ctx context.Context, msg publisher.Message) error
+}
+
+type RelayConfig struct {
+	// Optional
+
+	// BatchSize is the maximum number of messages published in each transaction, default 100.
+	BatchSize int
+	// PollInterval is how long the relay waits when there are no messages, default 1 second.
+	PollInterval time.Duration
+	// Retention is how long published rows are kept before being deleted, default 1 hour.
+	Retention time.Duration
+	// CleanupInterval is how often published rows are deleted, default 1 minute.
+	CleanupInterval time.Duration
+}
+
+// Relay publishes the messages in an outbox. Several relays can run against the same table,
+// the messages for any one key are only published by one relay at a time.
+// Relay satisfies system.MetricProducer.
+type Relay struct {
+	outbox *Outbox
+	tx     *db.TxManager
+	pub    Publisher
+	cfg    RelayConfig
+
+	published atomic.Int64
+	failed    atomic.Int64
+	deleted   atomic.Int64
+}
+
+func NewRelay(outbox *Outbox, tx *db.TxManager, pub Publisher, cfg RelayConfig) *Relay {
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.Retention == 0 {
+		cfg.Retention = time.Hour
+	}
+	if cfg.CleanupInterval == 0 {
+		cfg.CleanupInterval = time.Minute
+	}
+	return &Relay{
+		outbox: outbox,
+		tx:     tx,
+		pub:    pub,
+		cfg:    cfg,
+	}
+}
+
+// LoadRelay will create a new Relay, and wire it into the provided System as a service with
+// metrics. The relay depends on the named services in dependsOn, which would typically be the
+// database and rabbit services.
+func LoadRelay(outbox *Outbox, tx *db.TxManager, pub Publisher, cfg RelayConfig,
+	dependsOn []string, sys *system.System) *Relay {
+
+	r := NewRelay(outbox, tx, pub, cfg)
+	sys.AddNamedService(system.Service{
+		Name:      "outbox-relay-" + outbox.table,
+		DependsOn: dependsOn,
+		Run: func(ctx context.Context, ready func()) error {
+			ready()
+			return r.Run(ctx)
+		},
+	})
+	sys.AddMetrics(r)
+	return r
+}
+
+// Run publishes messages, and deletes published messages, until the context is cancelled.
+func (r *Relay) Run(ctx context.Context) error {
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		worker.Run(ctx, worker.Config{
+			Name:               "outbox-relay-" + r.outbox.table,
+			NoWorkBackOff:      backoff.NewConstantBackOff(r.cfg.PollInterval),
+			MaxWorkTime:        time.Minute,
+			WorkFunc:           r.relay,
+			BackoffOnAllErrors: true,
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		worker.Run(ctx, worker.Config{
+			Name:               "outbox-cleanup-" + r.outbox.table,
+			NoWorkBackOff:      backoff.NewConstantBackOff(r.cfg.CleanupInterval),
+			MaxWorkTime:        time.Minute,
+			WorkFunc:           r.cleanup,
+			BackoffOnAllErrors: true,
+		})
+	}()
+	wg.Wait()
+	return nil
+}
+
+type row struct {
+	ID          int64     `db:"id"`
+	Key         string    `db:"ordering_key"`
+	Exchange    string    `db:"exchange"`
+	RoutingKey  string    `db:"routing_key"`
+	ContentType string    `db:"content_type"`
+	Headers     string    `db:"headers"`
+	Body        []byte    `db:"body"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// relay publishes a batch of messages in a transaction, marking them as published.
+// The batch is the oldest unpublished rows, including any in flight in another relay. Keyed rows
+// in the batch are only selected if this transaction holds the advisory lock for the key, so
+// that another relay can not publish later messages for the key while earlier ones are in
+// flight. If a message fails to publish no later messages with the same key are published in
+// the batch.
+func (r *Relay) relay(ctx context.Context) (err error) {
+	ctx, span := o11y.StartSpan(ctx, "outbox: relay")
+	defer o11y.End(span, &err)
+	span.AddField("table", r.outbox.table)
+	span.RecordMetric(o11y.Timing("outbox.relay", "table", "result"))
+	span.RecordMetric(o11y.Duration("outbox.lag", "lag_ms", "table"))
+
+	// The locks are taken in their own materialized query over the batch, so that they are
+	// not taken for rows outside it, which would hold back those keys from other relays.
+	// #nosec - the table name is not user input
+	selectQuery := fmt.Sprintf(`
+WITH batch AS MATERIALIZED (
+  SELECT id, ordering_key
+  FROM %[1]s
+  WHERE published_at IS NULL
+  ORDER BY id
+  LIMIT $1
+), locked AS MATERIALIZED (
+  SELECT id
+  FROM batch
+  WHERE ordering_key = '' OR pg_try_advisory_xact_lock(hashtext('%[1]s'), hashtext(ordering_key))
+)
+SELECT o.id, o.ordering_key, o.exchange, o.routing_key, o.content_type, o.headers, o.body, o.created_at
+FROM %[1]s o
+JOIN locked USING (id)
+ORDER BY o.id
+FOR UPDATE OF o SKIP LOCKED;
+`, r.outbox.table)
+	// #nosec - the table name is not user input
+	updateQuery := fmt.Sprintf(`UPDATE %s SET published_at = now() WHERE id = any($1);`, r.outbox.table)
+
+	var rows []row
+	var published []int64
+	var failures int
+	err = r.tx.WithTx(ctx, func(ctx context.Context, q db.Querier) error {
+		rows, published, failures = nil, nil, 0
+		err := q.SelectContext(ctx, &rows, selectQuery, r.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		failedKeys := map[string]bool{}
+		for _, m := range rows {
+			if m.Key != "" && failedKeys[m.Key] {
+				continue
+			}
+			if err := r.publish(ctx, m); err != nil {
+				failures++
+				if m.Key != "" {
+					failedKeys[m.Key] = true
+				}
+				continue
+			}
+			published = append(published, m.ID)
+		}
+		if len(published) == 0 {
+			return nil
+		}
+		_, err = q.ExecContext(ctx, updateQuery, published)
+		return err
+	})
+	span.AddField("batch_size", len(rows))
+	span.AddField("published", len(published))
+	span.AddField("failed", failures)
+	if len(rows) > 0 {
+		span.AddField("lag_ms", time.Since(rows[0].CreatedAt).Milliseconds())
+	}
+	if err != nil {
+		// the messages may have been published, they will be published again
+		r.failed.Add(int64(len(rows)))
+		return err
+	}
+	r.published.Add(int64(len(published)))
+	r.failed.Add(int64(failures))
+
+	switch {
+	case failures > 0:
+		return worker.ErrShouldBackoff
+	case len(rows) < r.cfg.BatchSize:
+		return worker.ErrShouldBackoff
+	}
+	return nil
+}
+
+func (r *Relay) publish(ctx context.Context, m row) (err error) {
+	headers := map[string]string{}
+	if err := json.Unmarshal([]byte(m.Headers), &headers); err != nil {
+		return fmt.Errorf("unmarshal headers: %w", err)
+	}
+
+	// continue the trace the message was enqueued in
+	h := http.Header{}
+	table := amqp.Table{}
+	for k, v := range headers {
+		h.Set(k, v)
+		table[k] = v
+	}
+	ctx, span := o11y.FromContext(ctx).Helpers().InjectPropagation(ctx,
+		o11y.PropagationContextFromHeader(h), o11y.WithSpanKind(o11y.SpanKindProducer))
+	span.AddRawField("name", "outbox: publish")
+	defer o11y.End(span, &err)
+	span.AddField("id", m.ID)
+	span.AddField("key", m.Key)
+	span.AddField("lag_ms", time.Since(m.CreatedAt).Milliseconds())
+
+	return r.pub.Publish(ctx, publisher.Message{
+		Exchange: m.Exchange,
+		Key:      m.RoutingKey,
+		Publishing: amqp.Publishing{
+			Headers:      table,
+			ContentType:  m.ContentType,
+			DeliveryMode: amqp.Persistent,
+			Timestamp:    m.CreatedAt,
+			Body:         m.Body,
+		},
+	})
+}
+
+// cleanup deletes a batch of rows that were published longer ago than the retention period.
+func (r *Relay) cleanup(ctx context.Context) (err error) {
+	ctx, span := o11y.StartSpan(ctx, "outbox: cleanup")
+	defer o11y.End(span, &err)
+	span.AddField("table", r.outbox.table)
+
+	// #nosec - the table name is not user input
+	query := fmt.Sprintf(`
+DELETE FROM %[1]s
+WHERE id IN (
+  SELECT id FROM %[1]s
+  WHERE published_at IS NOT NULL AND published_at < $1
+  LIMIT 1000
+);
+`, r.outbox.table)
+	res, err := r.tx.NoTx().ExecContext(ctx, query, time.Now().Add(-r.cfg.Retention))
+	if err != nil && !errors.Is(err, db.ErrNop) {
+		return err
+	}
+	var deleted int64
+	if res != nil {
+		deleted, _ = res.RowsAffected()
+	}
+	span.AddField("deleted", deleted)
+	r.deleted.Add(deleted)
+	if deleted < 1000 {
+		return worker.ErrShouldBackoff
+	}
+	return nil
+}
+
+// MetricName returns the name for the metrics. (satisfies system.MetricProducer)
+func (r *Relay) MetricName() string {
+	return "outbox-" + r.outbox.table
+}
+
+// Gauges returns the number of pending messages and the age of the oldest pending message, along
+// with counts of the messages published, failed and deleted by this relay.
+// (satisfies system.MetricProducer)
+func (r *Relay) Gauges(ctx context.Context) map[string]float64 {
+	m := map[string]float64{
+		"published": float64(r.published.Load()),
+		"failed":    float64(r.failed.Load()),
+		"deleted":   float64(r.deleted.Load()),
+	}
+
+	var pending struct {
+		Count int64   `db:"count"`
+		Lag   float64 `db:"lag"`
+	}
+	// #nosec - the table name is not user input
+	query := fmt.Sprintf(`
+SELECT count(*) AS count, coalesce(extract(epoch FROM now() - min(created_at)), 0) AS lag
+FROM %s
+WHERE published_at IS NULL;
+`, r.outbox.table)
+	if err := r.tx.NoTx().GetContext(ctx, &pending, query); err == nil {
+		m["pending"] = float64(pending.Count)
+		m["lag_seconds"] = pending.Lag
+	}
+	return m
+}
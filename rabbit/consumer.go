@@ -0,0 +1,391 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/makasim/amqpextra"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/circleci/ex/o11y"
+)
+
+// attemptHeader records how many times a message has been handled.
+const attemptHeader = "x-ex-attempt"
+
+// Handler handles a delivered message. If it returns nil the message is acked. If it returns an
+// error the message is retried after a delay until MaxAttempts is reached, at which point it is
+// dead-lettered. Errors wrapped with NoRetry are dead-lettered straight away.
+// Handlers must not ack or nack the message themselves.
+type Handler func(ctx context.Context, msg amqp.Delivery) error
+
+type noRetryError struct {
+	err error
+}
+
+func (e noRetryError) Error() string { return e.err.Error() }
+func (e noRetryError) Unwrap() error { return e.err }
+
+// NoRetry marks a handler error as permanent, so the message is dead-lettered without retrying.
+func NoRetry(err error) error {
+	return noRetryError{err: err}
+}
+
+type ConsumerConfig struct {
+	// Queue is the name of the queue to consume from. It must already exist.
+	Queue string
+
+	// Optional
+
+	// Prefetch is the number of unacknowledged messages the broker will deliver, default 10.
+	Prefetch int
+	// Concurrency is the number of messages handled at the same time, default Prefetch.
+	Concurrency int
+	// HandlerTimeout is the maximum time a handler can take, default 1 minute.
+	HandlerTimeout time.Duration
+	// MaxAttempts is the number of times a message is handled before it is dead-lettered, default 5.
+	MaxAttempts int
+	// RetryDelays are the delays before each retry, the last delay is used for any further retries.
+	// Each delay has its own delay queue. The default is 1s, 10s then 1m.
+	RetryDelays []time.Duration
+	// DeadLetterQueue is where messages go once they have used all their attempts,
+	// default "<Queue>.dead".
+	DeadLetterQueue string
+
+	// DependsOn is the list of named system services that must be ready before the consumer
+	// starts, and that will only be stopped once the consumer has drained.
+	// It is only used when the consumer is created with LoadConsumer.
+	DependsOn []string
+}
+
+type connector interface {
+	Connection(ctx context.Context) (*amqp.Connection, error)
+}
+
+// Consumer consumes messages from a queue, handling them concurrently with manual acks.
+// Failed messages are retried via delay queues, and dead-lettered once they have used all
+// their attempts.
+type Consumer struct {
+	cfg     ConsumerConfig
+	handler Handler
+	dialer  connector
+
+	consuming    atomic.Bool
+	inFlight     atomic.Int64
+	acked        atomic.Int64
+	retried      atomic.Int64
+	deadLettered atomic.Int64
+}
+
+func NewConsumer(dialer *amqpextra.Dialer, cfg ConsumerConfig, handler Handler) *Consumer {
+	return newConsumer(dialer, cfg, handler)
+}
+
+func newConsumer(dialer connector, cfg ConsumerConfig, handler Handler) *Consumer {
+	if cfg.Prefetch == 0 {
+		cfg.Prefetch = 10
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = cfg.Prefetch
+	}
+	if cfg.HandlerTimeout == 0 {
+		cfg.HandlerTimeout = time.Minute
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 5
+	}
+	if len(cfg.RetryDelays) == 0 {
+		cfg.RetryDelays = []time.Duration{time.Second, 10 * time.Second, time.Minute}
+	}
+	if cfg.DeadLetterQueue == "" {
+		cfg.DeadLetterQueue = cfg.Queue + ".dead"
+	}
+	return &Consumer{
+		cfg:     cfg,
+		handler: handler,
+		dialer:  dialer,
+	}
+}
+
+// Run consumes messages until the context is cancelled, reconnecting if the connection is lost.
+// On cancellation no new messages are taken, any prefetched messages are requeued, and Run
+// waits for the in flight messages to be handled before returning.
+func (c *Consumer) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		err := c.consume(ctx)
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		o11y.LogError(ctx, "consumer: consume failed", err, o11y.Field("queue", c.cfg.Queue))
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+	return nil
+}
+
+// nolint: funlen
+func (c *Consumer) consume(ctx context.Context) error {
+	conn, err := c.dialer.Connection(ctx)
+	if err != nil {
+		return fmt.Errorf("connection: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("channel: %w", err)
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	// a separate channel in confirm mode so that a message is only acked once its retry or
+	// dead-letter copy has been confirmed by the broker
+	pub, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("publish channel: %w", err)
+	}
+	defer func() {
+		_ = pub.Close()
+	}()
+	if err := pub.Confirm(false); err != nil {
+		return fmt.Errorf("confirm: %w", err)
+	}
+
+	if err := c.declareTopology(ch); err != nil {
+		return err
+	}
+	if err := ch.Qos(c.cfg.Prefetch, 0, false); err != nil {
+		return fmt.Errorf("qos: %w", err)
+	}
+
+	tag := "ex-" + uuid.NewString()
+	deliveries, err := ch.Consume(c.cfg.Queue, tag, false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("consume: %w", err)
+	}
+	c.consuming.Store(true)
+	defer c.consuming.Store(false)
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	sem := make(chan struct{}, c.cfg.Concurrency)
+
+	for {
+		var d amqp.Delivery
+		var ok bool
+		select {
+		case <-ctx.Done():
+			c.drain(ch, tag, deliveries)
+			return nil
+		case d, ok = <-deliveries:
+			if !ok {
+				return errors.New("deliveries channel closed")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			_ = d.Nack(false, true)
+			c.drain(ch, tag, deliveries)
+			return nil
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_ = c.handle(ctx, pub, d)
+		}()
+	}
+}
+
+// drain stops any new deliveries and requeues any that were prefetched but not yet handled.
+func (c *Consumer) drain(ch *amqp.Channel, tag string, deliveries <-chan amqp.Delivery) {
+	if err := ch.Cancel(tag, false); err != nil {
+		return
+	}
+	for d := range deliveries {
+		_ = d.Nack(false, true)
+	}
+}
+
+func (c *Consumer) declareTopology(ch *amqp.Channel) error {
+	for _, delay := range c.cfg.RetryDelays {
+		_, err := ch.QueueDeclare(c.retryQueue(delay), true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": c.cfg.Queue,
+		})
+		if err != nil {
+			return fmt.Errorf("declare retry queue: %w", err)
+		}
+	}
+	_, err := ch.QueueDeclare(c.cfg.DeadLetterQueue, true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("declare dead letter queue: %w", err)
+	}
+	return nil
+}
+
+func (c *Consumer) retryQueue(delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", c.cfg.Queue, delay)
+}
+
+func (c *Consumer) retryDelay(attempt int) time.Duration {
+	i := min(attempt-1, len(c.cfg.RetryDelays)-1)
+	return c.cfg.RetryDelays[i]
+}
+
+func (c *Consumer) handle(ctx context.Context, pub *amqp.Channel, d amqp.Delivery) (err error) {
+	ctx, span := SpanFromDelivery(ctx, "consumer: handle", d)
+	defer o11y.End(span, &err)
+	span.RecordMetric(o11y.Timing("consumer.handle", "queue", "outcome", "result"))
+
+	attempt := attempts(d) + 1
+	span.AddRawField("messaging.destination.name", c.cfg.Queue)
+	span.AddField("queue", c.cfg.Queue)
+	span.AddField("exchange", d.Exchange)
+	span.AddField("key", d.RoutingKey)
+	span.AddField("redelivered", d.Redelivered)
+	span.AddField("attempt", attempt)
+
+	c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+
+	err = c.callHandler(ctx, span, d)
+	if err == nil {
+		span.AddField("outcome", "acked")
+		c.acked.Add(1)
+		return d.Ack(false)
+	}
+
+	var noRetry noRetryError
+	queue := c.retryQueue(c.retryDelay(attempt))
+	outcome := "retried"
+	if errors.As(err, &noRetry) || attempt >= c.cfg.MaxAttempts {
+		queue = c.cfg.DeadLetterQueue
+		outcome = "dead_lettered"
+	}
+	span.AddField("outcome", outcome)
+
+	// the message is being handled, so do not wait for the consumer to finish with the context
+	pctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if perr := republish(pctx, pub, queue, d, attempt, err); perr != nil {
+		span.AddField("outcome", "requeued")
+		o11y.LogError(ctx, "consumer: republish failed", perr, o11y.Field("queue", queue))
+		return errors.Join(err, d.Nack(false, true))
+	}
+
+	if outcome == "retried" {
+		c.retried.Add(1)
+	} else {
+		c.deadLettered.Add(1)
+	}
+	return errors.Join(err, d.Ack(false))
+}
+
+// callHandler calls the handler, allowing it to complete even if the consumer is shutting down,
+// and converting any panic into an error.
+func (c *Consumer) callHandler(ctx context.Context, span o11y.Span, d amqp.Delivery) (err error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.cfg.HandlerTimeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = NoRetry(o11y.HandlePanic(ctx, span, r, nil))
+		}
+	}()
+	return c.handler(ctx, d)
+}
+
+func republish(ctx context.Context, pub *amqp.Channel, queue string, d amqp.Delivery, attempt int,
+	handlerErr error) error {
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[attemptHeader] = int32(attempt)
+	headers["x-ex-error"] = handlerErr.Error()
+
+	dc, err := pub.PublishWithDeferredConfirmWithContext(ctx, "", queue, true, false, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	})
+	if err != nil {
+		return err
+	}
+	ok, err := dc.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("publish was not confirmed")
+	}
+	return nil
+}
+
+// attempts returns the number of times the message has already been handled.
+func attempts(d amqp.Delivery) int {
+	switch v := d.Headers[attemptHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// MetricName returns the name of the Consumer
+func (c *Consumer) MetricName() string {
+	return "consumer-" + c.cfg.Queue
+}
+
+// Gauges returns internal measures of the health of the Consumer
+func (c *Consumer) Gauges(_ context.Context) map[string]float64 {
+	consuming := 0.0
+	if c.consuming.Load() {
+		consuming = 1
+	}
+	return map[string]float64{
+		"consuming":     consuming,
+		"in_flight":     float64(c.inFlight.Load()),
+		"concurrency":   float64(c.cfg.Concurrency),
+		"prefetch":      float64(c.cfg.Prefetch),
+		"acked":         float64(c.acked.Load()),
+		"retried":       float64(c.retried.Load()),
+		"dead_lettered": float64(c.deadLettered.Load()),
+	}
+}
+
+// HealthChecks returns a ready check that fails while the consumer is not consuming.
+func (c *Consumer) HealthChecks() (name string, ready, live func(ctx context.Context) error) {
+	return "rabbit-consumer-" + c.cfg.Queue, func(_ context.Context) error {
+		if !c.consuming.Load() {
+			return errors.New("consumer is not consuming")
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,119 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/makasim/amqpextra/publisher"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+	"gotest.tools/v3/poll"
+
+	"github.com/circleci/ex/testing/rabbitfixture"
+)
+
+func TestConsumer_Run(t *testing.T) {
+	ctx, _ := newMetricsFixture(t)
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+
+	u := rabbitfixture.New(ctx, t)
+	dialer := createQueueAndListener(ctx, t, u)
+	pool := createPool(ctx, t, u)
+
+	var handled atomic.Int64
+	c := NewConsumer(dialer, ConsumerConfig{
+		Queue:       queueName,
+		MaxAttempts: 3,
+		RetryDelays: []time.Duration{10 * time.Millisecond},
+	}, func(ctx context.Context, msg amqp.Delivery) error {
+		handled.Add(1)
+		switch string(msg.Body) {
+		case "fail-once":
+			if attempts(msg) == 0 {
+				return errors.New("transient failure")
+			}
+		case "fail-always":
+			return errors.New("always fails")
+		case "permanent":
+			return NoRetry(errors.New("bad message"))
+		}
+		return nil
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	_, ready, _ := c.HealthChecks()
+	poll.WaitOn(t, func(t poll.LogT) poll.Result {
+		if err := ready(ctx); err != nil {
+			return poll.Continue("consumer not ready: %v", err)
+		}
+		return poll.Success()
+	})
+
+	for _, body := range []string{"ok", "fail-once", "fail-always", "permanent"} {
+		err := pool.Publish(ctx, publisher.Message{
+			Key:        queueName,
+			Publishing: amqp.Publishing{Body: []byte(body)},
+		})
+		assert.Assert(t, err)
+	}
+
+	poll.WaitOn(t, func(t poll.LogT) poll.Result {
+		g := c.Gauges(ctx)
+		if g["acked"] != 2 || g["dead_lettered"] != 2 {
+			return poll.Continue("gauges not settled: %v", g)
+		}
+		return poll.Success()
+	})
+
+	t.Run("Check gauges", func(t *testing.T) {
+		assert.Check(t, cmp.DeepEqual(c.Gauges(ctx), map[string]float64{
+			"consuming":     1,
+			"in_flight":     0,
+			"concurrency":   10,
+			"prefetch":      10,
+			"acked":         2,
+			"retried":       3,
+			"dead_lettered": 2,
+		}))
+		// ok once, fail-once twice, fail-always three times and permanent once
+		assert.Check(t, cmp.Equal(handled.Load(), int64(7)))
+	})
+
+	t.Run("Check dead letter queue", func(t *testing.T) {
+		conn, err := dialer.Connection(ctx)
+		assert.Assert(t, err)
+		ch, err := conn.Channel()
+		assert.Assert(t, err)
+		t.Cleanup(func() {
+			assert.Check(t, ch.Close())
+		})
+
+		q, err := ch.QueueDeclarePassive(queueName+".dead", true, false, false, false, nil)
+		assert.Assert(t, err)
+		assert.Check(t, cmp.Equal(q.Messages, 2))
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		cancel()
+		assert.Check(t, <-done)
+		assert.Check(t, cmp.ErrorContains(ready(context.Background()), "not consuming"))
+	})
+}
+
+func TestConsumer_RetryDelay(t *testing.T) {
+	c := newConsumer(nil, ConsumerConfig{Queue: "q"}, nil)
+	assert.Check(t, cmp.Equal(c.retryQueue(c.retryDelay(1)), "q.retry.1s"))
+	assert.Check(t, cmp.Equal(c.retryQueue(c.retryDelay(2)), "q.retry.10s"))
+	assert.Check(t, cmp.Equal(c.retryQueue(c.retryDelay(3)), "q.retry.1m0s"))
+	assert.Check(t, cmp.Equal(c.retryQueue(c.retryDelay(10)), "q.retry.1m0s"))
+	assert.Check(t, cmp.Equal(c.cfg.DeadLetterQueue, "q.dead"))
+}
@@ -1,4 +1,9 @@
 /*
-Package rabbit contains an experimental RabbitMQ publishing client.
+Package rabbit contains an experimental RabbitMQ publishing and consuming client.
+
+The Consumer handles messages concurrently with manual acknowledgements. Failed messages are
+retried by republishing them to delay queues named "<queue>.retry.<delay>", which dead-letter
+them back onto the original queue when their TTL expires. Once a message has used all of its
+attempts it is moved to the dead letter queue.
 */
 package rabbit
@@ -0,0 +1,45 @@
+package rabbit
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/circleci/ex/o11y"
+)
+
+// InjectPropagation returns a copy of headers with the trace context and baggage in ctx added,
+// so that the consumer of the message can continue the trace. Any existing propagation headers
+// are replaced.
+func InjectPropagation(ctx context.Context, headers amqp.Table) amqp.Table {
+	pc := o11y.FromContext(ctx).Helpers().ExtractPropagation(ctx)
+
+	out := make(amqp.Table, len(headers)+len(pc.Headers))
+	for k, v := range headers {
+		out[k] = v
+	}
+	for k := range pc.Headers {
+		// amqp headers are case-sensitive, so use the lower case W3C names
+		out[strings.ToLower(k)] = pc.Headers.Get(k)
+	}
+	return out
+}
+
+// SpanFromDelivery starts a consumer span that continues the trace propagated in the message
+// headers, in the same way o11y.SpanFromPropagation does for propagation strings. Any
+// propagated baggage is added to the returned context. If the message carries no trace context
+// a new trace is started.
+func SpanFromDelivery(ctx context.Context, name string, d amqp.Delivery) (context.Context, o11y.Span) {
+	h := http.Header{}
+	for k, v := range d.Headers {
+		if s, ok := v.(string); ok {
+			h.Set(k, s)
+		}
+	}
+	ctx, span := o11y.FromContext(ctx).Helpers().InjectPropagation(ctx,
+		o11y.PropagationContextFromHeader(h), o11y.WithSpanKind(o11y.SpanKindConsumer))
+	span.AddRawField("name", name)
+	return ctx, span
+}
@@ -0,0 +1,59 @@
+package rabbit
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/o11y"
+	"github.com/circleci/ex/o11y/otel"
+)
+
+func TestPropagation(t *testing.T) {
+	p, err := otel.New(otel.Config{})
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		p.Close(context.Background())
+	})
+
+	var headers amqp.Table
+	var traceID string
+	func() {
+		ctx := o11y.WithProvider(context.Background(), p)
+		ctx, span := o11y.StartSpan(ctx, "publish", o11y.WithSpanKind(o11y.SpanKindProducer))
+		defer span.End()
+		ctx = o11y.WithBaggage(ctx, o11y.Baggage{
+			"bg1": "bgv1",
+		})
+		traceID, _ = p.Helpers().TraceIDs(ctx)
+		headers = InjectPropagation(ctx, amqp.Table{"existing": "value"})
+	}()
+
+	t.Run("Check headers", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(headers["existing"], "value"))
+		assert.Check(t, cmp.Contains(headers, "traceparent"))
+		assert.Check(t, cmp.Contains(headers, "baggage"))
+	})
+
+	t.Run("Check delivery continues the trace", func(t *testing.T) {
+		ctx := o11y.WithProvider(context.Background(), p)
+		ctx, span := SpanFromDelivery(ctx, "consume", amqp.Delivery{Headers: headers})
+		defer span.End()
+
+		gotTraceID, _ := p.Helpers().TraceIDs(ctx)
+		assert.Check(t, cmp.Equal(gotTraceID, traceID))
+		assert.Check(t, cmp.Equal(o11y.GetBaggage(ctx)["bg1"], "bgv1"))
+	})
+
+	t.Run("Check delivery without headers starts a new trace", func(t *testing.T) {
+		ctx := o11y.WithProvider(context.Background(), p)
+		ctx, span := SpanFromDelivery(ctx, "consume", amqp.Delivery{})
+		defer span.End()
+
+		gotTraceID, _ := p.Helpers().TraceIDs(ctx)
+		assert.Check(t, gotTraceID != traceID)
+	})
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/circleci/ex/o11y"
 )
 
+// PublisherPool publishes messages using a pool of publishers. The trace context and baggage of
+// the publishing context are added to every message's headers, see SpanFromDelivery.
 type PublisherPool struct {
 	pool *pool.ObjectPool
 	name string
@@ -145,6 +147,7 @@ func (p *PublisherPool) publishMandatory(ctx context.Context, msg publisher.Mess
 
 func (p *PublisherPool) publish(ctx context.Context, msg publisher.Message) error {
 	msg.Context = ctx
+	msg.Publishing.Headers = InjectPropagation(ctx, msg.Publishing.Headers)
 
 	obj, err := p.pool.BorrowObject(ctx)
 	if err != nil {
@@ -16,6 +16,9 @@ type Config struct {
 	QueueName      string
 }
 
+// Load will create a new publisher pool, and wire it into the provided System with
+// default lifecycle management and observability. If ConnectionName is set the pool is added
+// as a named service called "rabbit-<ConnectionName>" so that other services can depend on it.
 func Load(ctx context.Context, cfg Config, sys *system.System) (*PublisherPool, error) {
 	dialer, err := amqpextra.NewDialer(
 		amqpextra.WithURL(cfg.URL.Raw()),
@@ -35,5 +38,52 @@ func Load(ctx context.Context, cfg Config, sys *system.System) (*PublisherPool,
 	sys.AddCleanup(pool.Close)
 	sys.AddMetrics(pool)
 
+	if cfg.ConnectionName != "" {
+		sys.AddNamedService(system.Service{
+			Name: "rabbit-" + cfg.ConnectionName,
+			Run:  system.ReadyUntilDone,
+		})
+	}
+
 	return pool, nil
 }
+
+// LoadConsumer will create a new Consumer with its own connection, and wire it into the provided
+// System as a named service called "rabbit-consumer-<Queue>" with a health check and metrics.
+// When the system shuts down the consumer stops taking messages, and waits for the messages
+// in flight to be handled before the services in ccfg.DependsOn are stopped.
+func LoadConsumer(ctx context.Context, cfg Config, ccfg ConsumerConfig, handler Handler,
+	sys *system.System) (*Consumer, error) {
+
+	connName := cfg.ConnectionName
+	if connName == "" {
+		connName = "consumer-" + ccfg.Queue
+	}
+	dialer, err := amqpextra.NewDialer(
+		amqpextra.WithContext(ctx),
+		amqpextra.WithURL(cfg.URL.Raw()),
+		amqpextra.WithConnectionProperties(amqp.Table{
+			"connection_name": connName,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	sys.AddCleanup(func(ctx context.Context) error {
+		dialer.Close()
+		return nil
+	})
+
+	c := NewConsumer(dialer, ccfg, handler)
+	sys.AddNamedService(system.Service{
+		Name:      "rabbit-consumer-" + ccfg.Queue,
+		DependsOn: ccfg.DependsOn,
+		Run: func(ctx context.Context, ready func()) error {
+			ready()
+			return c.Run(ctx)
+		},
+	})
+	sys.AddHealthCheck(c)
+	sys.AddMetrics(c)
+	return c, nil
+}
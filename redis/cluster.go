@@ -12,7 +12,8 @@ import (
 )
 
 type ClusterOptions struct {
-	// Name of the client for metrics and health check, default is "redis"
+	// Name of the client for metrics and health check, default is "redis". If it is set the
+	// client is also a named system service when added with LoadCluster, see system.AddNamedService.
 	Name string
 
 	// A seed list of host:port addresses of cluster nodes.
@@ -4,5 +4,8 @@ Package redis contains wiring and observability for the go-redis Redis client.
 There is support for:
 - observability (both for queries and connection info)
 - health checks
+- distributed locks with fencing tokens and automatic renewal
+- leader election
+- rate limit token buckets shared across replicas, for the ginrouter rate limit middleware
 */
 package redis
@@ -0,0 +1,163 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/circleci/ex/o11y"
+	"github.com/circleci/ex/system"
+)
+
+type ElectionConfig struct {
+	// Name of the election, replicas taking part in the same election must use the same name.
+	Name string
+
+	// Optional
+
+	// TTL is how long leadership is held without being renewed, the default is 15 seconds.
+	TTL time.Duration
+	// RetryInterval is how often followers try to become the leader, the default is TTL / 3.
+	RetryInterval time.Duration
+	// OnElected if set is called whenever this replica becomes the leader. The context is
+	// cancelled when leadership is lost or the election is stopped.
+	OnElected func(ctx context.Context) error
+}
+
+// Elector takes part in a leader election, so that only one replica of a service is the leader
+// at any time. It satisfies system.HealthChecker and system.GaugeProducer.
+type Elector struct {
+	locker *Locker
+	cfg    ElectionConfig
+
+	leader atomic.Bool
+	token  atomic.Int64
+
+	mu      sync.RWMutex
+	lastErr error
+}
+
+func NewElector(locker *Locker, cfg ElectionConfig) *Elector {
+	if cfg.TTL == 0 {
+		cfg.TTL = 15 * time.Second
+	}
+	if cfg.RetryInterval == 0 {
+		cfg.RetryInterval = cfg.TTL / 3
+	}
+	return &Elector{
+		locker: locker,
+		cfg:    cfg,
+	}
+}
+
+// LoadElector will create a new Elector, and wire it into the provided System as a service with
+// a health check and leadership gauge.
+func LoadElector(locker *Locker, cfg ElectionConfig, sys *system.System) *Elector {
+	e := NewElector(locker, cfg)
+	sys.AddService(e.Run)
+	sys.AddHealthCheck(e)
+	sys.AddGauges(e)
+	return e
+}
+
+// Run takes part in the election until the context is cancelled. If this replica is the
+// leader the leadership is released when Run returns.
+func (e *Elector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.cfg.RetryInterval)
+	defer ticker.Stop()
+	for {
+		e.campaign(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign tries to become the leader, and if it does blocks until leadership is lost.
+func (e *Elector) campaign(ctx context.Context) {
+	lease, err := e.locker.TryLock(ctx, "election:"+e.cfg.Name, e.cfg.TTL)
+	switch {
+	case errors.Is(err, ErrNotAcquired):
+		e.setErr(nil)
+		return
+	case err != nil:
+		e.setErr(err)
+		return
+	}
+	e.setErr(nil)
+
+	e.leader.Store(true)
+	e.token.Store(lease.Token())
+	o11y.Log(ctx, "redis: elected leader", o11y.Field("election", e.cfg.Name),
+		o11y.Field("token", lease.Token()))
+
+	if e.cfg.OnElected != nil {
+		if err := e.cfg.OnElected(lease.Context()); err != nil {
+			o11y.LogError(ctx, "redis: leader func failed", err, o11y.Field("election", e.cfg.Name))
+		}
+	}
+	<-lease.Context().Done()
+	e.leader.Store(false)
+
+	cause := context.Cause(lease.Context())
+	o11y.Log(ctx, "redis: leadership ended", o11y.Field("election", e.cfg.Name),
+		o11y.Field("cause", cause))
+
+	// use a fresh context, as the leadership may have ended due to the context being cancelled
+	rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second*5)
+	defer cancel()
+	if err := lease.Release(rctx); err != nil {
+		o11y.LogError(ctx, "redis: release leadership failed", err, o11y.Field("election", e.cfg.Name))
+	}
+}
+
+func (e *Elector) setErr(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastErr = err
+}
+
+// IsLeader reports whether this replica is currently the leader.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Token returns the fencing token of the current or most recent leadership.
+func (e *Elector) Token() int64 {
+	return e.token.Load()
+}
+
+// HealthChecks returns a ready check that fails if the last attempt to take part in the
+// election could not reach Redis. Followers are healthy. (satisfies system.HealthChecker)
+func (e *Elector) HealthChecks() (name string, ready, live func(ctx context.Context) error) {
+	return "leader-election-" + e.cfg.Name, func(_ context.Context) error {
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		if e.lastErr != nil {
+			return fmt.Errorf("leader election failed: %w", e.lastErr)
+		}
+		return nil
+	}, nil
+}
+
+// GaugeName returns the name for the gauges. (satisfies system.GaugeProducer)
+func (e *Elector) GaugeName() string {
+	return "leader-election"
+}
+
+// Gauges reports 1 when this replica is the leader, otherwise 0. (satisfies system.GaugeProducer)
+func (e *Elector) Gauges(_ context.Context) map[string][]system.TaggedValue {
+	var leader float64
+	if e.IsLeader() {
+		leader = 1
+	}
+	return map[string][]system.TaggedValue{
+		"leader": {{Val: leader, Tags: []string{"election:" + e.cfg.Name}}},
+	}
+}
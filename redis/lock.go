@@ -0,0 +1,225 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/circleci/ex/o11y"
+)
+
+var (
+	// ErrNotAcquired is returned by TryLock when the lock is held by someone else.
+	ErrNotAcquired = errors.New("lock not acquired")
+	// ErrLeaseLost is the cause of the lease context being cancelled when the lease could not be renewed.
+	ErrLeaseLost = errors.New("lease lost")
+	// ErrLeaseReleased is the cause of the lease context being cancelled when the lease was released.
+	ErrLeaseReleased = errors.New("lease released")
+)
+
+// The lock and fence keys share a hash tag so that the scripts work on a cluster.
+func lockKey(key string) string  { return "lock:{" + key + "}" }
+func fenceKey(key string) string { return "lock:{" + key + "}:fence" }
+
+var (
+	// acquireScript sets the lock if it is not held, and returns the new fencing token,
+	// or zero if the lock was not acquired.
+	acquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+	// renewScript extends the lock only if it is still held by the same owner.
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+	// releaseScript deletes the lock only if it is still held by the same owner.
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+)
+
+// Locker takes distributed locks stored in Redis. It works with both single node and
+// cluster clients.
+type Locker struct {
+	client redis.UniversalClient
+}
+
+func NewLocker(client redis.UniversalClient) *Locker {
+	return &Locker{client: client}
+}
+
+// TryLock attempts to take the lock once, returning ErrNotAcquired if it is held by someone else.
+// The returned Lease is renewed automatically until it is released, or the context is cancelled.
+func (l *Locker) TryLock(ctx context.Context, key string, ttl time.Duration) (_ *Lease, err error) {
+	ctx, span := o11y.StartSpan(ctx, "redis: try-lock")
+	defer o11y.End(span, &err)
+	span.AddField("key", key)
+	span.AddField("ttl", ttl)
+	if ttl < time.Millisecond {
+		return nil, errors.New("lock ttl must be at least one millisecond")
+	}
+
+	owner := uuid.NewString()
+	token, err := acquireScript.Run(ctx, l.client, []string{lockKey(key), fenceKey(key)},
+		owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return nil, fmt.Errorf("acquire lock: %w", err)
+	}
+	span.AddField("acquired", token > 0)
+	if token == 0 {
+		return nil, ErrNotAcquired
+	}
+	span.AddField("token", token)
+
+	return newLease(ctx, l.client, key, owner, token, ttl), nil
+}
+
+// Lock waits until the lock can be taken, retrying every retry interval until the context is
+// cancelled.
+func (l *Locker) Lock(ctx context.Context, key string, ttl, retry time.Duration) (*Lease, error) {
+	ticker := time.NewTicker(retry)
+	defer ticker.Stop()
+	for {
+		lease, err := l.TryLock(ctx, key, ttl)
+		if !errors.Is(err, ErrNotAcquired) {
+			return lease, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Acquire takes the lock for the ttl without renewing it, and without releasing it. It returns
+// false if the lock is held by someone else. (satisfies scheduler.Locker)
+func (l *Locker) Acquire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	lease, err := l.TryLock(ctx, key, ttl)
+	switch {
+	case errors.Is(err, ErrNotAcquired):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	lease.stopRenewing()
+	return true, nil
+}
+
+// Lease is a held lock. The lease context is cancelled if the lease can not be renewed, so
+// any work protected by the lock should use it.
+type Lease struct {
+	client redis.UniversalClient
+	key    string
+	owner  string
+	token  int64
+	ttl    time.Duration
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+}
+
+func newLease(ctx context.Context, client redis.UniversalClient, key, owner string, token int64,
+	ttl time.Duration) *Lease {
+
+	lctx, cancel := context.WithCancelCause(ctx)
+	le := &Lease{
+		client: client,
+		key:    key,
+		owner:  owner,
+		token:  token,
+		ttl:    ttl,
+		ctx:    lctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go le.renew()
+	return le
+}
+
+// Context returns a context that is cancelled when the lease is lost or released.
+// context.Cause will return ErrLeaseLost if the lease could not be renewed.
+func (le *Lease) Context() context.Context {
+	return le.ctx
+}
+
+// Token returns the fencing token for this lease. Tokens increase every time the lock is
+// acquired, so storage protected by the lock can reject writes from older lease holders.
+func (le *Lease) Token() int64 {
+	return le.token
+}
+
+// Release stops renewing the lease and deletes the lock if it is still held by this lease.
+func (le *Lease) Release(ctx context.Context) (err error) {
+	ctx, span := o11y.StartSpan(ctx, "redis: release-lock")
+	defer o11y.End(span, &err)
+	span.AddField("key", le.key)
+	span.AddField("token", le.token)
+
+	le.cancel(ErrLeaseReleased)
+	<-le.done
+
+	released, err := releaseScript.Run(ctx, le.client, []string{lockKey(le.key)}, le.owner).Int64()
+	if err != nil {
+		return fmt.Errorf("release lock: %w", err)
+	}
+	span.AddField("released", released == 1)
+	return nil
+}
+
+func (le *Lease) stopRenewing() {
+	le.cancel(ErrLeaseReleased)
+	<-le.done
+}
+
+// renew extends the lease every third of the ttl. If the lock is no longer held, or the lease
+// could not be renewed before it expired, the lease context is cancelled.
+func (le *Lease) renew() {
+	defer close(le.done)
+
+	ticker := time.NewTicker(le.ttl / 3)
+	defer ticker.Stop()
+
+	expires := time.Now().Add(le.ttl)
+	for {
+		select {
+		case <-le.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if time.Now().After(expires) {
+			le.cancel(ErrLeaseLost)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(le.ctx, le.ttl/3)
+		start := time.Now()
+		renewed, err := renewScript.Run(ctx, le.client, []string{lockKey(le.key)},
+			le.owner, le.ttl.Milliseconds()).Int64()
+		cancel()
+		switch {
+		case err != nil:
+			// try again on the next tick, until the lease would have expired
+			o11y.LogError(le.ctx, "redis: lease renewal failed", err, o11y.Field("key", le.key))
+		case renewed == 0:
+			le.cancel(ErrLeaseLost)
+			return
+		default:
+			expires = start.Add(le.ttl)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+	"gotest.tools/v3/poll"
+
+	"github.com/circleci/ex/scheduler"
+	"github.com/circleci/ex/testing/redisfixture"
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+var _ scheduler.Locker = (*Locker)(nil)
+
+func TestLocker(t *testing.T) {
+	ctx := testcontext.Background()
+	fix := redisfixture.Setup(ctx, t, redisfixture.Connection{Addr: "localhost:6379"})
+	locker := NewLocker(fix.Client)
+
+	lease, err := locker.TryLock(ctx, "a-lock", 300*time.Millisecond)
+	assert.Assert(t, err)
+	assert.Check(t, cmp.Equal(lease.Token(), int64(1)))
+
+	t.Run("held lock is not acquired", func(t *testing.T) {
+		_, err := locker.TryLock(ctx, "a-lock", time.Second)
+		assert.Check(t, cmp.ErrorIs(err, ErrNotAcquired))
+	})
+
+	t.Run("lease is renewed", func(t *testing.T) {
+		time.Sleep(time.Second)
+		assert.Check(t, lease.Context().Err())
+		_, err := locker.TryLock(ctx, "a-lock", time.Second)
+		assert.Check(t, cmp.ErrorIs(err, ErrNotAcquired))
+	})
+
+	t.Run("released lock can be taken with a higher token", func(t *testing.T) {
+		assert.Check(t, lease.Release(ctx))
+		assert.Check(t, cmp.ErrorIs(context.Cause(lease.Context()), ErrLeaseReleased))
+
+		lease2, err := locker.TryLock(ctx, "a-lock", time.Second)
+		assert.Assert(t, err)
+		assert.Check(t, cmp.Equal(lease2.Token(), int64(2)))
+		assert.Check(t, lease2.Release(ctx))
+	})
+
+	t.Run("lease is lost", func(t *testing.T) {
+		lease, err := locker.TryLock(ctx, "lost-lock", 300*time.Millisecond)
+		assert.Assert(t, err)
+		assert.Assert(t, fix.Del(ctx, lockKey("lost-lock")).Err())
+
+		select {
+		case <-lease.Context().Done():
+		case <-time.After(time.Second):
+			t.Fatal("lease was not lost")
+		}
+		assert.Check(t, cmp.ErrorIs(context.Cause(lease.Context()), ErrLeaseLost))
+	})
+
+	t.Run("acquire expires", func(t *testing.T) {
+		ok, err := locker.Acquire(ctx, "expiring", 100*time.Millisecond)
+		assert.Assert(t, err)
+		assert.Check(t, ok)
+
+		ok, err = locker.Acquire(ctx, "expiring", 100*time.Millisecond)
+		assert.Assert(t, err)
+		assert.Check(t, !ok)
+
+		time.Sleep(200 * time.Millisecond)
+		ok, err = locker.Acquire(ctx, "expiring", 100*time.Millisecond)
+		assert.Assert(t, err)
+		assert.Check(t, ok)
+	})
+}
+
+func TestElector(t *testing.T) {
+	ctx, cancel := context.WithCancel(testcontext.Background())
+	defer cancel()
+	fix := redisfixture.Setup(ctx, t, redisfixture.Connection{Addr: "localhost:6379"})
+	locker := NewLocker(fix.Client)
+
+	cfg := ElectionConfig{
+		Name: "test",
+		TTL:  300 * time.Millisecond,
+	}
+	e1 := NewElector(locker, cfg)
+	e2 := NewElector(locker, cfg)
+
+	ctx1, cancel1 := context.WithCancel(ctx)
+	defer cancel1()
+	go func() { _ = e1.Run(ctx1) }()
+
+	poll.WaitOn(t, func(t poll.LogT) poll.Result {
+		if !e1.IsLeader() {
+			return poll.Continue("e1 is not the leader")
+		}
+		return poll.Success()
+	})
+
+	go func() { _ = e2.Run(ctx) }()
+	time.Sleep(500 * time.Millisecond)
+	assert.Check(t, !e2.IsLeader())
+
+	_, ready, _ := e2.HealthChecks()
+	assert.Check(t, ready(ctx))
+	assert.Check(t, cmp.Equal(e2.Gauges(ctx)["leader"][0].Val, float64(0)))
+
+	// stopping the leader hands over leadership
+	cancel1()
+	poll.WaitOn(t, func(t poll.LogT) poll.Result {
+		if !e2.IsLeader() {
+			return poll.Continue("e2 is not the leader")
+		}
+		return poll.Success()
+	})
+	assert.Check(t, !e1.IsLeader())
+	assert.Check(t, e2.Token() > e1.Token())
+	assert.Check(t, cmp.Equal(e2.Gauges(ctx)["leader"][0].Val, float64(1)))
+}
@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript takes a token from the bucket, refilling it for the time since it was last
+// used. It returns zero if a token was taken, or otherwise the milliseconds until one will
+// be available. The Redis clock is used so that every replica agrees on the time.
+var takeScript = redis.NewScript(`
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "at")
+local tokens = tonumber(bucket[1]) or burst
+local at = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * rate / 1000)
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "at", now)
+-- once the bucket has refilled it is the same as a new one
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate))
+return wait
+`)
+
+// RateLimitStore holds rate limit token buckets in Redis, so that the limits are shared by
+// every replica. (satisfies ginrouter.RateLimitStore)
+type RateLimitStore struct {
+	client redis.UniversalClient
+}
+
+func NewRateLimitStore(client redis.UniversalClient) *RateLimitStore {
+	return &RateLimitStore{client: client}
+}
+
+// Take takes a token from the bucket for the key, which holds up to burst tokens and is
+// refilled at rate tokens a second. If there are none, it returns how long until there
+// will be one.
+func (s *RateLimitStore) Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	// the script divides by the rate, and an empty bucket would never allow a request
+	if rate <= 0 || burst < 1 {
+		return 0, fmt.Errorf("take rate limit token: invalid rate %v or burst %d", rate, burst)
+	}
+	wait, err := takeScript.Run(ctx, s.client, []string{"ratelimit:{" + key + "}"}, rate, burst).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("take rate limit token: %w", err)
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
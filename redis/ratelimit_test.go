@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+
+	"github.com/circleci/ex/httpserver/ginrouter"
+	"github.com/circleci/ex/testing/redisfixture"
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+var _ ginrouter.RateLimitStore = (*RateLimitStore)(nil)
+
+func TestRateLimitStore(t *testing.T) {
+	ctx := testcontext.Background()
+	fix := redisfixture.Setup(ctx, t, redisfixture.Connection{Addr: "localhost:6379"})
+	store := NewRateLimitStore(fix.Client)
+
+	take := func(t *testing.T, key string) time.Duration {
+		t.Helper()
+		retryAfter, err := store.Take(ctx, key, 10, 2)
+		assert.Assert(t, err)
+		return retryAfter
+	}
+
+	t.Run("burst is allowed then limited", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(take(t, "a-client"), time.Duration(0)))
+		assert.Check(t, cmp.Equal(take(t, "a-client"), time.Duration(0)))
+		retryAfter := take(t, "a-client")
+		assert.Check(t, retryAfter > 0 && retryAfter <= 100*time.Millisecond, retryAfter)
+	})
+
+	t.Run("buckets are separate", func(t *testing.T) {
+		assert.Check(t, cmp.Equal(take(t, "another-client"), time.Duration(0)))
+	})
+
+	t.Run("bucket refills", func(t *testing.T) {
+		time.Sleep(200 * time.Millisecond)
+		assert.Check(t, cmp.Equal(take(t, "a-client"), time.Duration(0)))
+	})
+
+	t.Run("refilled buckets expire", func(t *testing.T) {
+		ttl, err := fix.PTTL(ctx, "ratelimit:{a-client}").Result()
+		assert.Assert(t, err)
+		assert.Check(t, ttl > 0 && ttl <= 200*time.Millisecond, ttl)
+	})
+
+	t.Run("zero rate is rejected", func(t *testing.T) {
+		_, err := store.Take(ctx, "zero-rate", 0, 2)
+		assert.Check(t, cmp.ErrorContains(err, "invalid rate 0"))
+	})
+}
@@ -13,7 +13,8 @@ import (
 )
 
 type Options struct {
-	// Name of the client for metrics and health check, default is "redis"
+	// Name of the client for metrics and health check, default is "redis". If it is set the
+	// client is also a named system service when added with Load, see system.AddNamedService.
 	Name string
 
 	Host string
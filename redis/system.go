@@ -9,7 +9,8 @@ import (
 )
 
 // Load will create a new Redis client, and wire it into the provided System with
-// default lifecycle management and observability.
+// default lifecycle management and observability. If Name is set the client is added as a
+// named service with that name so that other services can depend on it.
 func Load(o Options, sys *system.System) *redis.Client {
 	client := New(o)
 
@@ -23,12 +24,19 @@ func Load(o Options, sys *system.System) *redis.Client {
 	}
 	sys.AddHealthCheck(NewHealthCheck(client, name))
 	sys.AddMetrics(NewMetrics(name, client))
+	if o.Name != "" {
+		sys.AddNamedService(system.Service{
+			Name: o.Name,
+			Run:  system.ReadyUntilDone,
+		})
+	}
 
 	return client
 }
 
 // LoadCluster will create a new Redis cluster client, and wire it into the provided System with
-// default lifecycle management and observability.
+// default lifecycle management and observability. If Name is set the client is added as a
+// named service with that name so that other services can depend on it.
 func LoadCluster(o ClusterOptions, sys *system.System) *redis.ClusterClient {
 	client := NewCluster(o)
 
@@ -42,6 +50,12 @@ func LoadCluster(o ClusterOptions, sys *system.System) *redis.ClusterClient {
 	}
 	sys.AddHealthCheck(NewHealthCheck(client, name))
 	sys.AddMetrics(NewMetrics(name, client))
+	if o.Name != "" {
+		sys.AddNamedService(system.Service{
+			Name: o.Name,
+			Run:  system.ReadyUntilDone,
+		})
+	}
 
 	return client
 }
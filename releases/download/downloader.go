@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -151,27 +152,15 @@ func (d *Downloader) downloadFile(ctx context.Context, url, target string, perm
 		timeout = d.downloadAttemptTimeout
 	}
 
+	// each attempt streams the body into the file from the start
 	err = d.client.Call(ctx, httpclient.NewRequest("GET", url,
 		httpclient.Timeout(timeout),
-		httpclient.SuccessDecoder(func(r io.Reader) (err error) {
-			defer func() {
-				if err == nil {
-					return
-				}
-
-				cErr := clearFile(out)
-				if cErr != nil {
-					// We've failed to clean up the file, so it's now unsafe to retry (because the file potentially contains
-					// data). The HTTP client will retry context.DeadlineExceeded errors from the decoder, so if the original
-					// error was a context.DeadlineExceeded then we need to swallow it to prevent retries. Other errors are not
-					// retried from the decoder so are safe to return.
-					if errors.Is(err, context.DeadlineExceeded) {
-						err = cErr
-					}
-				}
-			}()
-
-			_, err = io.Copy(out, r)
+		httpclient.StreamResponse(func(body io.Reader, _ http.Header) error {
+			err := clearFile(out)
+			if err != nil {
+				return fmt.Errorf("could not clear file %q: %w", target, err)
+			}
+			_, err = io.Copy(out, body)
 			if err != nil {
 				return fmt.Errorf("could not write file %q: %w", target, err)
 			}
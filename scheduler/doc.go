@@ -0,0 +1,9 @@
+/*
+Package scheduler runs periodic jobs on cron expressions or fixed intervals.
+
+Each job is run by a worker.Run loop, so every run is traced with the same worker_loop span
+and metrics as any other worker. Jobs can have jitter added to their run times, a policy for
+runs that were missed because a previous run overran, and an optional Locker so that only one
+replica of a service runs each scheduled run.
+*/
+package scheduler
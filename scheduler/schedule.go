@@ -0,0 +1,212 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule describes when a job should run.
+type Schedule interface {
+	// Next returns the next time the job should run after t. A zero time means the job
+	// will never run again.
+	Next(t time.Time) time.Time
+}
+
+type every time.Duration
+
+// Every returns a schedule that runs at a fixed interval. It panics if the interval is not
+// positive.
+func Every(interval time.Duration) Schedule {
+	if interval <= 0 {
+		panic(fmt.Sprintf("scheduler: every %s: interval must be positive", interval))
+	}
+	return every(interval)
+}
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+type cronSchedule struct {
+	minute [60]bool
+	hour   [24]bool
+	dom    [32]bool
+	month  [13]bool
+	dow    [7]bool
+
+	// anyDom and anyDow record a '*' so that the day matching follows the standard cron rule
+	// of a day matching either field when both are restricted.
+	anyDom bool
+	anyDow bool
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a standard five field cron expression (minute, hour, day of month, month and
+// day of week) into a Schedule. Fields support '*', lists, ranges and steps, for example
+// "*/15 9-17 * * 1-5". The descriptors @yearly, @monthly, @weekly, @daily, @hourly and
+// "@every <duration>" are also supported.
+// Times are matched in the location of the time passed to Next.
+func ParseCron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := strings.CutPrefix(expr, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %w", expr, err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("cron %q: interval must be positive", expr)
+		}
+		return Every(interval), nil
+	}
+	if d, ok := descriptors[expr]; ok {
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: expected 5 fields but got %d", expr, len(fields))
+	}
+
+	c := &cronSchedule{}
+	sets := []struct {
+		name     string
+		set      []bool
+		min, max int
+		any      *bool
+	}{
+		{name: "minute", set: c.minute[:], min: 0, max: 59},
+		{name: "hour", set: c.hour[:], min: 0, max: 23},
+		{name: "day of month", set: c.dom[:], min: 1, max: 31, any: &c.anyDom},
+		{name: "month", set: c.month[:], min: 1, max: 12},
+		// 7 is allowed as an alias for Sunday
+		{name: "day of week", set: make([]bool, 8), min: 0, max: 7, any: &c.anyDow},
+	}
+	for i, s := range sets {
+		star, err := parseField(fields[i], s.set, s.min, s.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %s: %w", expr, s.name, err)
+		}
+		if s.any != nil {
+			*s.any = star
+		}
+	}
+	copy(c.dow[:], sets[4].set[:7])
+	c.dow[0] = c.dow[0] || sets[4].set[7]
+
+	if c.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron %q: never matches a time", expr)
+	}
+	return c, nil
+}
+
+// MustParseCron is like ParseCron but panics if the expression cannot be parsed.
+func MustParseCron(expr string) Schedule {
+	s, err := ParseCron(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// parseField sets the values matched by the comma separated field, and reports if the field is '*'.
+func parseField(field string, set []bool, min, max int) (star bool, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			step, err = strconv.Atoi(s)
+			if err != nil || step < 1 {
+				return false, fmt.Errorf("invalid step %q", s)
+			}
+			part = r
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+			star = star || step == 1
+		case strings.Contains(part, "-"):
+			l, h, _ := strings.Cut(part, "-")
+			if lo, err = parseValue(l, min, max); err != nil {
+				return false, err
+			}
+			if hi, err = parseValue(h, min, max); err != nil {
+				return false, err
+			}
+			if lo > hi {
+				return false, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			if lo, err = parseValue(part, min, max); err != nil {
+				return false, err
+			}
+			// a single value with a step, e.g. 5/15, runs from the value to the max
+			if step == 1 {
+				hi = lo
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return star, nil
+}
+
+func parseValue(s string, min, max int) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, min, max)
+	}
+	return v, nil
+}
+
+// Next returns the first matching minute after t, searching up to five years ahead.
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !c.month[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !c.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !c.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom[t.Day()]
+	dow := c.dow[t.Weekday()]
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+	return dom || dow
+}
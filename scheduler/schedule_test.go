@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestParseCron_Next(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2024, 1, 10, 10, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want []time.Time
+	}{
+		{
+			expr: "* * * * *",
+			want: []time.Time{
+				time.Date(2024, 1, 10, 10, 31, 0, 0, time.UTC),
+				time.Date(2024, 1, 10, 10, 32, 0, 0, time.UTC),
+			},
+		},
+		{
+			expr: "*/20 * * * *",
+			want: []time.Time{
+				time.Date(2024, 1, 10, 10, 40, 0, 0, time.UTC),
+				time.Date(2024, 1, 10, 11, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			expr: "5,10 9-10 * * *",
+			want: []time.Time{
+				time.Date(2024, 1, 11, 9, 5, 0, 0, time.UTC),
+				time.Date(2024, 1, 11, 9, 10, 0, 0, time.UTC),
+				time.Date(2024, 1, 11, 10, 5, 0, 0, time.UTC),
+			},
+		},
+		{
+			expr: "0 0 * * 7",
+			want: []time.Time{
+				time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 21, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			// either the 1st or a Friday
+			expr: "0 12 1 * 5",
+			want: []time.Time{
+				time.Date(2024, 1, 12, 12, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 19, 12, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 26, 12, 0, 0, 0, time.UTC),
+				time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			expr: "0 0 29 2 *",
+			want: []time.Time{
+				time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+				time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			expr: "@monthly",
+			want: []time.Time{
+				time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			expr: "@every 90m",
+			want: []time.Time{
+				time.Date(2024, 1, 10, 12, 0, 15, 0, time.UTC),
+				time.Date(2024, 1, 10, 13, 30, 15, 0, time.UTC),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := ParseCron(tt.expr)
+			assert.Assert(t, err)
+
+			var got []time.Time
+			next := from
+			for range tt.want {
+				next = s.Next(next)
+				got = append(got, next)
+			}
+			assert.Check(t, cmp.DeepEqual(got, tt.want))
+		})
+	}
+}
+
+func TestParseCron_Errors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "* * * *", wantErr: `cron "* * * *": expected 5 fields but got 4`},
+		{expr: "60 * * * *", wantErr: `cron "60 * * * *": minute: value 60 out of range [0, 59]`},
+		{expr: "* 5-1 * * *", wantErr: `cron "* 5-1 * * *": hour: invalid range "5-1"`},
+		{expr: "*/0 * * * *", wantErr: `cron "*/0 * * * *": minute: invalid step "0"`},
+		{expr: "* * x * *", wantErr: `cron "* * x * *": day of month: invalid value "x"`},
+		{expr: "0 0 31 2 *", wantErr: `cron "0 0 31 2 *": never matches a time`},
+		{expr: "@every -1s", wantErr: `cron "@every -1s": interval must be positive`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseCron(tt.expr)
+			assert.Check(t, cmp.Error(err, tt.wantErr))
+		})
+	}
+}
+
+func TestEvery_NotPositive(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		t.Run(interval.String(), func(t *testing.T) {
+			defer func() {
+				assert.Check(t, cmp.Equal(recover(), "scheduler: every "+interval.String()+": interval must be positive"))
+			}()
+			Every(interval)
+		})
+	}
+}
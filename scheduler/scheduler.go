@@ -0,0 +1,233 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/circleci/ex/o11y"
+	"github.com/circleci/ex/worker"
+)
+
+// MissedRunPolicy decides what happens to runs that were due while a previous run was
+// still running, or while the process was unable to run them.
+type MissedRunPolicy int
+
+const (
+	// Skip drops any missed runs, the job next runs at the first scheduled time after the
+	// previous run finished.
+	Skip MissedRunPolicy = iota
+	// CatchUpOnce runs the job once straight away for all the missed runs.
+	CatchUpOnce
+	// CatchUpAll runs the job straight away once for each missed run.
+	CatchUpAll
+)
+
+// maxMissedRuns bounds the number of missed runs that will be counted or caught up on.
+const maxMissedRuns = 1000
+
+// Locker is used to make sure only one replica of a service runs each scheduled run of a job.
+type Locker interface {
+	// Acquire attempts to take the lock with the given key for the ttl. It returns false
+	// if the lock is already held. The lock is not released by the scheduler, it is expected to
+	// expire after the ttl.
+	Acquire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// Job is a function that is run on a schedule.
+type Job struct {
+	// Name identifies the job in spans and metrics, and is used as the worker loop name.
+	Name string
+	// Schedule decides when the job runs, see ParseCron and Every.
+	Schedule Schedule
+	// Func is the work to do on each run.
+	Func func(ctx context.Context) error
+
+	// Optional
+
+	// RunOnStart runs the job as soon as the scheduler starts, before following the schedule.
+	RunOnStart bool
+	// Jitter adds a random delay of up to this duration to each run, to spread out the load
+	// of jobs that are scheduled at the same time, for instance on many replicas.
+	Jitter time.Duration
+	// MissedRuns is the policy for runs that were missed, it defaults to Skip.
+	MissedRuns MissedRunPolicy
+	// MaxRunTime is the duration after which the context passed to Func will be cancelled.
+	// It defaults to one hour.
+	MaxRunTime time.Duration
+	// Locker if set is used to ensure only one replica runs each scheduled run.
+	// The lock key includes the scheduled time of the run, so the replicas must agree on the
+	// schedule (for cron schedules this means the same location).
+	Locker Locker
+	// LockTTL is how long the lock for a run is held. It should be longer than the jitter plus
+	// any clock skew between replicas. It defaults to MaxRunTime.
+	LockTTL time.Duration
+}
+
+// Scheduler runs a set of jobs.
+type Scheduler struct {
+	jobs []Job
+
+	now func() time.Time // purely a test hook
+}
+
+// New creates an empty scheduler.
+func New() *Scheduler {
+	return &Scheduler{
+		now: time.Now,
+	}
+}
+
+// Add adds a job to the scheduler. It must be called before Run.
+func (s *Scheduler) Add(j Job) error {
+	switch {
+	case j.Name == "":
+		return errors.New("scheduler: a job name is required")
+	case j.Schedule == nil:
+		return fmt.Errorf("scheduler: job %q: a schedule is required", j.Name)
+	case j.Func == nil:
+		return fmt.Errorf("scheduler: job %q: a func is required", j.Name)
+	}
+	if j.MaxRunTime == 0 {
+		j.MaxRunTime = time.Hour
+	}
+	if j.LockTTL == 0 {
+		j.LockTTL = j.MaxRunTime
+	}
+	s.jobs = append(s.jobs, j)
+	return nil
+}
+
+// Run runs all the jobs until the context is cancelled.
+// It is intended to be added to a system.System with AddService.
+func (s *Scheduler) Run(ctx context.Context) error {
+	wg := sync.WaitGroup{}
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runJob(ctx, j)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) runJob(ctx context.Context, j Job) {
+	r := &jobRunner{
+		job: j,
+		now: s.now,
+	}
+	start := s.now()
+	if j.RunOnStart {
+		r.next = start
+	} else {
+		r.next = j.Schedule.Next(start)
+	}
+	if r.next.IsZero() {
+		return
+	}
+
+	timer := time.NewTimer(r.NextBackOff())
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	worker.Run(ctx, worker.Config{
+		Name:               j.Name,
+		NoWorkBackOff:      r,
+		MaxWorkTime:        j.MaxRunTime,
+		WorkFunc:           r.work,
+		BackoffOnAllErrors: true,
+	})
+}
+
+// jobRunner is the WorkFunc for the job, as well as the worker back off which waits until the
+// next scheduled run.
+type jobRunner struct {
+	job Job
+	now func() time.Time
+
+	// next is the scheduled time of the next run, it is only accessed by the worker loop.
+	next time.Time
+}
+
+func (r *jobRunner) work(ctx context.Context) (err error) {
+	scheduled := r.next
+	if scheduled.IsZero() {
+		return worker.ErrShouldBackoff
+	}
+	o11y.AddField(ctx, "scheduled_at", scheduled)
+	o11y.AddField(ctx, "lateness_ms", r.now().Sub(scheduled).Milliseconds())
+	defer func() {
+		o11y.AddField(ctx, "missed_runs", r.advance(scheduled))
+	}()
+
+	if r.job.Locker != nil {
+		key := fmt.Sprintf("scheduler:%s:%d", r.job.Name, scheduled.Unix())
+		acquired, err := r.job.Locker.Acquire(ctx, key, r.job.LockTTL)
+		if err != nil {
+			return fmt.Errorf("acquire lock: %w", err)
+		}
+		o11y.AddField(ctx, "lock_acquired", acquired)
+		if !acquired {
+			return worker.ErrShouldBackoff
+		}
+	}
+
+	err = r.job.Func(ctx)
+	if err != nil {
+		return err
+	}
+	return worker.ErrShouldBackoff
+}
+
+// advance moves next on from the run that was scheduled, applying the missed run policy, and
+// returns the number of runs that were missed.
+func (r *jobRunner) advance(scheduled time.Time) int {
+	now := r.now()
+	next := r.job.Schedule.Next(scheduled)
+	if next.IsZero() || next.After(now) {
+		r.next = next
+		return 0
+	}
+
+	missed := 0
+	latest := next
+	for t := next; !t.IsZero() && !t.After(now) && missed < maxMissedRuns; t = r.job.Schedule.Next(t) {
+		missed++
+		latest = t
+	}
+
+	switch r.job.MissedRuns {
+	case CatchUpOnce:
+		r.next = latest
+	case CatchUpAll:
+		r.next = next
+	default:
+		r.next = r.job.Schedule.Next(now)
+	}
+	return missed
+}
+
+// NextBackOff returns the time until the next run, plus any jitter. (satisfies backoff.BackOff)
+func (r *jobRunner) NextBackOff() time.Duration {
+	if r.next.IsZero() {
+		// the schedule has finished, so check back infrequently
+		return 24 * time.Hour
+	}
+	wait := max(r.next.Sub(r.now()), 0)
+	if r.job.Jitter > 0 {
+		wait += rand.N(r.job.Jitter)
+	}
+	return wait
+}
+
+// Reset is a no-op as the wait only depends on the schedule. (satisfies backoff.BackOff)
+func (r *jobRunner) Reset() {}
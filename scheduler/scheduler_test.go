@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+	"gotest.tools/v3/poll"
+
+	"github.com/circleci/ex/o11y"
+	"github.com/circleci/ex/o11y/otel"
+	"github.com/circleci/ex/testing/fakemetrics"
+	"github.com/circleci/ex/testing/testcontext"
+	"github.com/circleci/ex/worker"
+)
+
+func TestScheduler_Run(t *testing.T) {
+	metrics := &fakemetrics.Provider{}
+	p, err := otel.New(otel.Config{
+		Metrics: metrics,
+	})
+	assert.NilError(t, err)
+	ctx, cancel := context.WithCancel(o11y.WithProvider(context.Background(), p))
+	defer cancel()
+
+	var runs atomic.Int32
+	s := New()
+	err = s.Add(Job{
+		Name:       "test-job",
+		Schedule:   Every(10 * time.Millisecond),
+		RunOnStart: true,
+		Jitter:     time.Millisecond,
+		Func: func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		},
+	})
+	assert.NilError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		assert.Check(t, s.Run(ctx))
+		close(done)
+	}()
+
+	poll.WaitOn(t, func(t poll.LogT) poll.Result {
+		if runs.Load() < 3 {
+			return poll.Continue("only %d runs", runs.Load())
+		}
+		return poll.Success()
+	})
+	cancel()
+	<-done
+
+	var loops int
+	for _, c := range metrics.Calls() {
+		if c.Name == "worker_loop" {
+			loops++
+			assert.Check(t, cmp.DeepEqual(c.Tags, []string{"loop_name:test-job", "result:success"}))
+		}
+	}
+	assert.Check(t, loops >= 3)
+}
+
+func TestScheduler_Add_Errors(t *testing.T) {
+	s := New()
+	f := func(ctx context.Context) error { return nil }
+
+	err := s.Add(Job{Schedule: Every(time.Second), Func: f})
+	assert.Check(t, cmp.Error(err, "scheduler: a job name is required"))
+
+	err = s.Add(Job{Name: "a", Func: f})
+	assert.Check(t, cmp.Error(err, `scheduler: job "a": a schedule is required`))
+
+	err = s.Add(Job{Name: "a", Schedule: Every(time.Second)})
+	assert.Check(t, cmp.Error(err, `scheduler: job "a": a func is required`))
+}
+
+func TestJobRunner_MissedRuns(t *testing.T) {
+	start := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	// the run that started at 10:00 finished at 10:35, missing 3 runs
+	finished := start.Add(35 * time.Minute)
+
+	tests := []struct {
+		name     string
+		policy   MissedRunPolicy
+		wantNext time.Time
+	}{
+		{name: "skip", policy: Skip, wantNext: start.Add(40 * time.Minute)},
+		{name: "catch-up-once", policy: CatchUpOnce, wantNext: start.Add(30 * time.Minute)},
+		{name: "catch-up-all", policy: CatchUpAll, wantNext: start.Add(10 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &jobRunner{
+				job: Job{
+					Schedule:   MustParseCron("*/10 * * * *"),
+					MissedRuns: tt.policy,
+				},
+				now: func() time.Time { return finished },
+			}
+			missed := r.advance(start)
+			assert.Check(t, cmp.Equal(missed, 3))
+			assert.Check(t, cmp.Equal(r.next, tt.wantNext))
+			assert.Check(t, cmp.Equal(r.NextBackOff(), max(tt.wantNext.Sub(finished), 0)))
+		})
+	}
+
+	t.Run("not missed", func(t *testing.T) {
+		r := &jobRunner{
+			job: Job{Schedule: Every(time.Hour)},
+			now: func() time.Time { return finished },
+		}
+		assert.Check(t, cmp.Equal(r.advance(start), 0))
+		assert.Check(t, cmp.Equal(r.next, start.Add(time.Hour)))
+	})
+}
+
+func TestJobRunner_Locker(t *testing.T) {
+	ctx := testcontext.Background()
+	now := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	locker := &fakeLocker{}
+
+	var runs int
+	job := Job{
+		Name:     "locked",
+		Schedule: Every(time.Minute),
+		Func: func(ctx context.Context) error {
+			runs++
+			return nil
+		},
+		Locker:  locker,
+		LockTTL: time.Minute,
+	}
+
+	// two replicas running the same scheduled run
+	for range 2 {
+		r := &jobRunner{job: job, now: func() time.Time { return now }, next: now}
+		err := r.work(ctx)
+		assert.Check(t, cmp.ErrorIs(err, worker.ErrShouldBackoff))
+		assert.Check(t, cmp.Equal(r.next, now.Add(time.Minute)))
+	}
+	assert.Check(t, cmp.Equal(runs, 1))
+	assert.Check(t, cmp.DeepEqual(locker.keys, []string{"scheduler:locked:1704880800"}))
+
+	t.Run("error", func(t *testing.T) {
+		locker.err = errors.New("boom")
+		r := &jobRunner{job: job, now: func() time.Time { return now }, next: now}
+		err := r.work(ctx)
+		assert.Check(t, cmp.Error(err, "acquire lock: boom"))
+		assert.Check(t, cmp.Equal(runs, 1))
+	})
+}
+
+type fakeLocker struct {
+	mu   sync.Mutex
+	keys []string
+	err  error
+}
+
+func (l *fakeLocker) Acquire(_ context.Context, key string, _ time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return false, l.err
+	}
+	for _, k := range l.keys {
+		if k == key {
+			return false, nil
+		}
+	}
+	l.keys = append(l.keys, key)
+	return true, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/circleci/ex/internal/gauge"
 	"github.com/circleci/ex/o11y"
 )
 
@@ -16,10 +17,7 @@ type GaugeProducer interface {
 	Gauges(context.Context) map[string][]TaggedValue
 }
 
-type TaggedValue struct {
-	Val  float64
-	Tags []string
-}
+type TaggedValue = gauge.TaggedValue
 
 func emitGauges(ctx context.Context, producers []GaugeProducer) {
 	metrics := o11y.FromContext(ctx).MetricsProvider()
@@ -0,0 +1,145 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Service is a service that can be added to the system with AddNamedService.
+type Service struct {
+	// Name identifies the service so that other services can depend on it. It must be unique
+	// within the system. It may be empty if no other service depends on this one.
+	Name string
+	// DependsOn is the list of names of the services that must be ready before this service is
+	// started, and that will only be stopped once this service has returned.
+	DependsOn []string
+	// Run runs the service until the context is cancelled. It must call ready once the service
+	// is in a state where the services that depend on it can start.
+	Run func(ctx context.Context, ready func()) error
+}
+
+// ReadyUntilDone is a Service Run func for services that have no work of their own to do,
+// for instance connection pools, but that other services need to declare a dependency on.
+// It is immediately ready and returns when the context is cancelled.
+func ReadyUntilDone(ctx context.Context, ready func()) error {
+	ready()
+	<-ctx.Done()
+	return nil
+}
+
+type serviceNode struct {
+	svc        Service
+	deps       []*serviceNode
+	dependents []*serviceNode
+
+	readyOnce sync.Once
+	ready     chan struct{}
+	done      chan struct{}
+}
+
+// newServiceGraph links the services to their dependencies and checks that all the dependencies
+// exist and that there are no cycles.
+func newServiceGraph(services []Service) ([]*serviceNode, error) {
+	nodes := make([]*serviceNode, 0, len(services))
+	named := map[string]*serviceNode{}
+	for _, s := range services {
+		n := &serviceNode{
+			svc:   s,
+			ready: make(chan struct{}),
+			done:  make(chan struct{}),
+		}
+		if s.Name != "" {
+			if _, ok := named[s.Name]; ok {
+				return nil, fmt.Errorf("system: duplicate service name %q", s.Name)
+			}
+			named[s.Name] = n
+		}
+		nodes = append(nodes, n)
+	}
+
+	for _, n := range nodes {
+		for _, dep := range n.svc.DependsOn {
+			d, ok := named[dep]
+			if !ok {
+				return nil, fmt.Errorf("system: service %q depends on unknown service %q", n.svc.Name, dep)
+			}
+			n.deps = append(n.deps, d)
+			d.dependents = append(d.dependents, n)
+		}
+	}
+
+	state := map[*serviceNode]int{}
+	for _, n := range nodes {
+		if err := checkCycle(n, state); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+const (
+	cycleVisiting = iota + 1
+	cycleVisited
+)
+
+func checkCycle(n *serviceNode, state map[*serviceNode]int) error {
+	switch state[n] {
+	case cycleVisiting:
+		return fmt.Errorf("system: service %q has a circular dependency", n.svc.Name)
+	case cycleVisited:
+		return nil
+	}
+	state[n] = cycleVisiting
+	for _, d := range n.deps {
+		if err := checkCycle(d, state); err != nil {
+			return err
+		}
+	}
+	state[n] = cycleVisited
+	return nil
+}
+
+// run waits for all the dependencies to be ready then runs the service with the service context.
+// If the system is stopped before the dependencies are ready the service is never started.
+func (n *serviceNode) run(gctx, sctx context.Context) error {
+	defer close(n.done)
+
+	for _, d := range n.deps {
+		select {
+		case <-d.ready:
+		case <-d.done:
+			// the dependency may have become ready just before returning
+			select {
+			case <-d.ready:
+				continue
+			default:
+			}
+			return fmt.Errorf("system: service %q stopped before service %q was ready", d.svc.Name, n.svc.Name)
+		case <-gctx.Done():
+			return nil
+		}
+	}
+
+	return n.svc.Run(sctx, n.markReady)
+}
+
+func (n *serviceNode) markReady() {
+	n.readyOnce.Do(func() {
+		close(n.ready)
+	})
+}
+
+// stopWhenDependentsDone waits for the system to be stopped, then for all the services that
+// depend on this one to have returned, before cancelling this service.
+func (n *serviceNode) stopWhenDependentsDone(gctx context.Context, cancel context.CancelFunc) {
+	defer cancel()
+	select {
+	case <-gctx.Done():
+	case <-n.done:
+		return
+	}
+	for _, d := range n.dependents {
+		<-d.done
+	}
+}
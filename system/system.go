@@ -19,11 +19,12 @@ type HealthChecker interface {
 // It can collect a set of health check functions and return them as a list
 // (to pass into single health check handler for instance).
 type System struct {
-	services        []func(context.Context) error
+	services        []Service
 	healthChecks    []HealthChecker
 	gaugeProducers  []GaugeProducer
 	metricProducers []MetricProducer
 	cleanups        []func(ctx context.Context) error
+	state           *termination.State
 }
 
 // New create a new system with a context that can be used to coordinate
@@ -32,41 +33,63 @@ type System struct {
 // It is expected that the context cancelled when the service receives a signal,
 // but will also be cancelled when any of the services returns an error.
 // The context may be cancelled by the caller, to stop the services.
+// The system shutdown state is added as a health check, so that the system reports as
+// not ready as soon as a termination signal is received.
 func New() *System {
-	return &System{}
+	s := &System{
+		state: termination.NewState(),
+	}
+	s.AddHealthCheck(s.state)
+	return s
 }
 
-// The default handler will return an error when a termination signal is received
+// If set this handler is used in place of the system termination state handler.
 // This variable is defined purely for internal testing
-var terminationTestHook = termination.Handle
+var terminationTestHook func(ctx context.Context, delay time.Duration) error
 
 // Run runs any services added to the system, it also adds a signal handler that
 // a worker to gather and publish system metrics.
 // Run is blocking and will only return when all it's services have finished.
 // The error returned will be the first error returned from any of the services.
 // The terminationDelay passed in is the amount of time to wait between receiving a
-// signal and cancelling the system context
+// signal and cancelling the system context.
+// Services are started once the services they depend on are ready, and are stopped
+// before the services they depend on, see AddNamedService.
 func (r *System) Run(ctx context.Context, terminationDelay time.Duration) (err error) {
 	_, uptimeSpan := o11y.StartSpan(ctx, "system: run")
 	defer o11y.End(uptimeSpan, &err)
 	uptimeSpan.RecordMetric(o11y.Timing("system.run", "result"))
 
-	g, ctx := errgroup.WithContext(ctx)
+	nodes, err := newServiceGraph(r.services)
+	if err != nil {
+		return err
+	}
+
+	handle := r.state.Handle
+	if terminationTestHook != nil {
+		handle = terminationTestHook
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
-		return terminationTestHook(ctx, terminationDelay)
+		return handle(gctx, terminationDelay)
 	})
 
-	for _, f := range r.services {
-		// Capture the func, so we don't overwrite it when the goroutines start in parallel.
-		f := f
+	ctx = termination.WithState(ctx, r.state)
+
+	for _, n := range nodes {
+		// Each service gets a context that is not cancelled by the group, so that
+		// the shutdown of the services can be staged in dependency order.
+		sctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		go n.stopWhenDependentsDone(gctx, cancel)
 		g.Go(func() error {
-			return f(ctx)
+			return n.run(gctx, sctx)
 		})
 	}
 
 	// if we have any metrics add the metrics worker
 	if len(r.metricProducers) > 0 || len(r.gaugeProducers) > 0 {
-		g.Go(metricsReporter(ctx, r.metricProducers, r.gaugeProducers))
+		g.Go(metricsReporter(gctx, r.metricProducers, r.gaugeProducers))
 	}
 
 	return g.Wait()
@@ -83,6 +106,22 @@ func (r *System) Run(ctx context.Context, terminationDelay time.Duration) (err e
 // in-flight work then the depended upon systems should remain active enough during a context
 // cancellation, and only full shut down via a cleanup function (for instance closing a database connection).
 func (r *System) AddService(s func(ctx context.Context) error) {
+	r.services = append(r.services, Service{
+		Run: func(ctx context.Context, ready func()) error {
+			ready()
+			return s(ctx)
+		},
+	})
+}
+
+// AddNamedService adds a service that other services can depend on by name, and that may
+// itself depend on other named services.
+// The service will only be started once all the services it depends on have called their
+// ready func. When the system shuts down the service context will only be cancelled once all the
+// services that depend on it have returned. This allows, for instance, an HTTP server to stop
+// accepting new requests and drain in-flight ones before the services it uses are stopped.
+// Services added with AddService have no name and are ready as soon as they are started.
+func (r *System) AddNamedService(s Service) {
 	r.services = append(r.services, s)
 }
 
@@ -110,6 +149,13 @@ func (r *System) AddCleanup(c func(ctx context.Context) error) {
 	r.cleanups = append(r.cleanups, c)
 }
 
+// ShutdownState returns the shutdown state of the system. Services can use this to stop taking
+// on new work as soon as the system starts draining. The same state is also available from the
+// context passed to each service via termination.FromContext.
+func (r *System) ShutdownState() *termination.State {
+	return r.state
+}
+
 // HealthChecks returns the list of previously stored health checkers. This list can
 // be used to report on the liveness and readiness of the system.
 func (r *System) HealthChecks() []HealthChecker {
@@ -17,6 +17,7 @@ import (
 	"github.com/circleci/ex/o11y/otel"
 	"github.com/circleci/ex/termination"
 	"github.com/circleci/ex/testing/fakemetrics"
+	"github.com/circleci/ex/testing/testcontext"
 )
 
 func TestSystem_Run(t *testing.T) {
@@ -123,6 +124,161 @@ func TestSystem_Run(t *testing.T) {
 	}, cmpMetrics))
 }
 
+func TestSystem_Run_DependencyOrder(t *testing.T) {
+	ctx := testcontext.Background()
+
+	defer func(h func(context.Context, time.Duration) error) { terminationTestHook = h }(terminationTestHook)
+	terminate := make(chan struct{})
+	terminationTestHook = func(ctx context.Context, delay time.Duration) error {
+		select {
+		case <-terminate:
+			return termination.ErrTerminated
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	mu := sync.Mutex{}
+	var events []string
+	record := func(e string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	}
+
+	sys := New()
+	// add the dependant first, to show the order of adding does not matter
+	sys.AddNamedService(Service{
+		Name:      "api",
+		DependsOn: []string{"db", "queue"},
+		Run: func(ctx context.Context, ready func()) error {
+			record("api started")
+			ready()
+			close(terminate)
+			<-ctx.Done()
+			record("api stopped")
+			return nil
+		},
+	})
+	sys.AddNamedService(Service{
+		Name:      "queue",
+		DependsOn: []string{"db"},
+		Run: func(ctx context.Context, ready func()) error {
+			record("queue started")
+			ready()
+			<-ctx.Done()
+			record("queue stopped")
+			return nil
+		},
+	})
+	sys.AddNamedService(Service{
+		Name: "db",
+		Run: func(ctx context.Context, ready func()) error {
+			record("db started")
+			time.Sleep(10 * time.Millisecond)
+			ready()
+			<-ctx.Done()
+			record("db stopped")
+			return nil
+		},
+	})
+
+	err := sys.Run(ctx, 0)
+	assert.Check(t, errors.Is(err, termination.ErrTerminated))
+	assert.Check(t, cmp.DeepEqual(events, []string{
+		"db started",
+		"queue started",
+		"api started",
+		"api stopped",
+		"queue stopped",
+		"db stopped",
+	}))
+}
+
+func TestSystem_ShutdownState(t *testing.T) {
+	ctx := testcontext.Background()
+	defer func(h func(context.Context, time.Duration) error) { terminationTestHook = h }(terminationTestHook)
+	terminationTestHook = func(ctx context.Context, delay time.Duration) error {
+		<-ctx.Done()
+		return nil
+	}
+
+	sys := New()
+	assert.Check(t, cmp.Contains(sys.HealthChecks(), sys.ShutdownState()))
+
+	sys.AddService(func(ctx context.Context) error {
+		state := termination.FromContext(ctx)
+		assert.Check(t, state == sys.ShutdownState())
+		state.Drain()
+		return errors.New("stopped")
+	})
+
+	err := sys.Run(ctx, 0)
+	assert.Check(t, cmp.Error(err, "stopped"))
+	assert.Check(t, cmp.Equal(sys.ShutdownState().Phase(), termination.Draining))
+}
+
+func TestSystem_Run_DependencyErrors(t *testing.T) {
+	ctx := testcontext.Background()
+	defer func(h func(context.Context, time.Duration) error) { terminationTestHook = h }(terminationTestHook)
+	terminationTestHook = func(ctx context.Context, delay time.Duration) error {
+		<-ctx.Done()
+		return nil
+	}
+
+	noop := func(ctx context.Context, ready func()) error {
+		return nil
+	}
+
+	tests := []struct {
+		name     string
+		services []Service
+		wantErr  string
+	}{
+		{
+			name: "unknown",
+			services: []Service{
+				{Name: "a", DependsOn: []string{"b"}, Run: noop},
+			},
+			wantErr: `system: service "a" depends on unknown service "b"`,
+		},
+		{
+			name: "duplicate",
+			services: []Service{
+				{Name: "a", Run: noop},
+				{Name: "a", Run: noop},
+			},
+			wantErr: `system: duplicate service name "a"`,
+		},
+		{
+			name: "cycle",
+			services: []Service{
+				{Name: "a", DependsOn: []string{"b"}, Run: noop},
+				{Name: "b", DependsOn: []string{"a"}, Run: noop},
+			},
+			wantErr: `system: service "a" has a circular dependency`,
+		},
+		{
+			name: "stopped-before-ready",
+			services: []Service{
+				{Name: "a", Run: noop},
+				{Name: "b", DependsOn: []string{"a"}, Run: noop},
+			},
+			wantErr: `system: service "a" stopped before service "b" was ready`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sys := New()
+			for _, s := range tt.services {
+				sys.AddNamedService(s)
+			}
+			err := sys.Run(ctx, 0)
+			assert.Check(t, cmp.Error(err, tt.wantErr))
+		})
+	}
+}
+
 var cmpMetrics = gocmp.Options{
 	cmpopts.IgnoreFields(fakemetrics.MetricCall{}, "Value"),
 	cmpopts.SortSlices(func(x, y fakemetrics.MetricCall) bool {
@@ -2,5 +2,10 @@
 Package termination contains a simple handler for termination signals.
 
 It is wired into system.System by default.
+
+The State type tracks the shutdown phases of a service, from Running, to Draining as soon as
+a signal is received, then Stopping once the termination delay has passed. The system adds the
+State as a health check so that readiness fails while draining, and makes it available to
+services via FromContext.
 */
 package termination
@@ -0,0 +1,126 @@
+package termination
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Phase is a phase of the shutdown of a service.
+type Phase int32
+
+const (
+	// Running is the normal phase of a service, where it accepts new work.
+	Running Phase = iota
+	// Draining is entered as soon as a shutdown signal is received. The service should be
+	// reported as not ready, and should stop picking up new work, but in flight work can continue.
+	Draining
+	// Stopping is entered once the termination delay has passed, and the services are being stopped.
+	Stopping
+)
+
+func (p Phase) String() string {
+	switch p {
+	case Running:
+		return "running"
+	case Draining:
+		return "draining"
+	case Stopping:
+		return "stopping"
+	}
+	return "unknown"
+}
+
+// ErrDraining is returned by the State readiness check once the service is no longer running.
+var ErrDraining = errors.New("service is shutting down")
+
+// State is a shutdown state machine that moves from Running to Draining then Stopping.
+// The phase only ever moves forwards. It is safe for concurrent use.
+type State struct {
+	phase atomic.Int32
+
+	once     sync.Once
+	draining chan struct{}
+}
+
+// NewState returns a State in the Running phase.
+func NewState() *State {
+	return &State{}
+}
+
+// Phase returns the current phase.
+func (s *State) Phase() Phase {
+	return Phase(s.phase.Load())
+}
+
+// Running returns true if the service has not started shutting down.
+func (s *State) Running() bool {
+	return s.Phase() == Running
+}
+
+// Draining returns a channel that is closed once the state leaves the Running phase.
+// Services such as workers can use this to stop picking up new work.
+func (s *State) Draining() <-chan struct{} {
+	s.once.Do(s.init)
+	return s.draining
+}
+
+// Drain moves the state to the Draining phase, if it has not already moved past it.
+func (s *State) Drain() {
+	s.advance(Draining)
+}
+
+// Stop moves the state to the Stopping phase.
+func (s *State) Stop() {
+	s.advance(Stopping)
+}
+
+func (s *State) advance(to Phase) {
+	s.once.Do(s.init)
+	for {
+		from := s.phase.Load()
+		if Phase(from) >= to {
+			return
+		}
+		if s.phase.CompareAndSwap(from, int32(to)) {
+			if Phase(from) == Running {
+				close(s.draining)
+			}
+			return
+		}
+	}
+}
+
+func (s *State) init() {
+	s.draining = make(chan struct{})
+}
+
+// HealthChecks returns a readiness check that fails as soon as the state leaves the Running
+// phase, so that load balancers stop sending traffic during the termination delay.
+// (satisfies system.HealthChecker)
+func (s *State) HealthChecks() (name string, ready, live func(ctx context.Context) error) {
+	return "termination", func(_ context.Context) error {
+		if !s.Running() {
+			return ErrDraining
+		}
+		return nil
+	}, nil
+}
+
+type stateKey struct{}
+
+// WithState returns a copy of the context carrying the state.
+func WithState(ctx context.Context, s *State) context.Context {
+	return context.WithValue(ctx, stateKey{}, s)
+}
+
+// FromContext returns the state stored in the context. If there is no state in the context
+// a State that is always Running is returned.
+func FromContext(ctx context.Context) *State {
+	s, ok := ctx.Value(stateKey{}).(*State)
+	if !ok {
+		return NewState()
+	}
+	return s
+}
@@ -0,0 +1,58 @@
+package termination
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestState(t *testing.T) {
+	ctx := context.Background()
+	s := NewState()
+	_, ready, live := s.HealthChecks()
+	assert.Check(t, cmp.Nil(live))
+
+	assert.Check(t, cmp.Equal(s.Phase(), Running))
+	assert.Check(t, ready(ctx))
+	select {
+	case <-s.Draining():
+		t.Fatal("should not be draining")
+	default:
+	}
+
+	s.Drain()
+	assert.Check(t, cmp.Equal(s.Phase(), Draining))
+	assert.Check(t, cmp.ErrorIs(ready(ctx), ErrDraining))
+	<-s.Draining()
+
+	s.Stop()
+	assert.Check(t, cmp.Equal(s.Phase(), Stopping))
+	assert.Check(t, cmp.Equal(s.Phase().String(), "stopping"))
+
+	t.Run("phases only move forwards", func(t *testing.T) {
+		s.Drain()
+		assert.Check(t, cmp.Equal(s.Phase(), Stopping))
+	})
+}
+
+func TestState_Handle_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := NewState()
+	err := s.Handle(ctx, 0)
+	assert.Check(t, err)
+	assert.Check(t, cmp.Equal(s.Phase(), Stopping))
+	<-s.Draining()
+}
+
+func TestFromContext(t *testing.T) {
+	s := NewState()
+	s.Drain()
+
+	ctx := WithState(context.Background(), s)
+	assert.Check(t, FromContext(ctx) == s)
+	assert.Check(t, FromContext(context.Background()).Running())
+}
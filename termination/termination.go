@@ -17,16 +17,28 @@ var ErrTerminated = o11y.NewWarning("terminated")
 // When a signal is received Handle returns ErrTerminated.
 // If the context is cancelled Handle will return with no error.
 func Handle(ctx context.Context, delay time.Duration) error {
+	return NewState().Handle(ctx, delay)
+}
+
+// Handle is the same as the package level Handle, but also moves the state through
+// the shutdown phases. When a signal is received the state is moved to Draining, and
+// once the delay has passed to Stopping, before returning ErrTerminated.
+// If the context is cancelled the state is moved to Stopping and Handle returns with no error.
+func (s *State) Handle(ctx context.Context, delay time.Duration) error {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
 	select {
-	case s := <-quit:
+	case sig := <-quit:
 		o := o11y.FromContext(ctx)
-		o.Log(ctx, "system: shutdown signal received", o11y.Field("signal", s),
+		o.Log(ctx, "system: shutdown signal received", o11y.Field("signal", sig),
 			o11y.Field("delay", delay))
+		s.Drain()
 		time.Sleep(delay)
+		s.Stop()
 		return ErrTerminated
 	case <-ctx.Done():
+		s.Stop()
 		return nil
 	}
 }
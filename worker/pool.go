@@ -0,0 +1,206 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+
+	"github.com/circleci/ex/internal/gauge"
+	"github.com/circleci/ex/o11y"
+)
+
+// PoolConfig configures a Pool. The embedded Config is used by every worker in the pool.
+type PoolConfig struct {
+	Config
+	// MinWorkers is the number of workers the pool starts with, and will never scale below.
+	// It defaults to 1.
+	MinWorkers int
+	// MaxWorkers is the maximum number of workers the pool will scale up to. If it is not more
+	// than MinWorkers the pool runs a fixed number of workers.
+	MaxWorkers int
+	// ScaleInterval is how often the pool considers scaling, it defaults to 10 seconds.
+	// If no worker needed to back off during the interval a worker is added, if more than half of
+	// the work loops in the interval backed off a worker is removed.
+	ScaleInterval time.Duration
+}
+
+// Pool runs a number of workers concurrently calling the same WorkFunc, all sharing the
+// same NoWorkBackOff policy.
+// Pool satisfies system.GaugeProducer so the number of active and idle workers, tagged with the
+// pool name, can be published by passing it to system.AddGauges.
+type Pool struct {
+	cfg   PoolConfig
+	stats poolStats
+
+	mu      sync.Mutex
+	nextID  int
+	cancels []context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewPool creates a pool of workers, the workers are not started until Run is called.
+func NewPool(cfg PoolConfig) *Pool {
+	cfg.Config = setDefaults(cfg.Config)
+	cfg.NoWorkBackOff = &lockedBackOff{b: cfg.NoWorkBackOff}
+	if cfg.MinWorkers < 1 {
+		cfg.MinWorkers = 1
+	}
+	if cfg.MaxWorkers < cfg.MinWorkers {
+		cfg.MaxWorkers = cfg.MinWorkers
+	}
+	if cfg.ScaleInterval == 0 {
+		cfg.ScaleInterval = 10 * time.Second
+	}
+	return &Pool{cfg: cfg}
+}
+
+// RunPool creates a pool of workers and runs it until the context is cancelled.
+func RunPool(ctx context.Context, cfg PoolConfig) {
+	NewPool(cfg).Run(ctx)
+}
+
+// Run starts MinWorkers workers, and if configured scales the workers up and down.
+// Run exits when the context is cancelled and all the workers have finished their current work.
+func (p *Pool) Run(ctx context.Context) {
+	p.cfg.NoWorkBackOff.Reset()
+
+	for i := 0; i < p.cfg.MinWorkers; i++ {
+		p.addWorker(ctx)
+	}
+
+	if p.cfg.MaxWorkers > p.cfg.MinWorkers {
+		p.scale(ctx)
+	}
+
+	<-ctx.Done()
+	p.wg.Wait()
+}
+
+func (p *Pool) scale(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.ScaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		loops, backoffs := p.stats.resetInterval()
+		workers := p.workers()
+		switch {
+		case loops > 0 && backoffs == 0 && workers < p.cfg.MaxWorkers:
+			p.addWorker(ctx)
+			o11y.Log(ctx, "worker: pool scaled up", o11y.Field("loop_name", p.cfg.Name),
+				o11y.Field("workers", workers+1))
+		case backoffs*2 > loops && workers > p.cfg.MinWorkers:
+			p.removeWorker()
+			o11y.Log(ctx, "worker: pool scaled down", o11y.Field("loop_name", p.cfg.Name),
+				o11y.Field("workers", workers-1))
+		}
+	}
+}
+
+func (p *Pool) addWorker(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	p.cancels = append(p.cancels, cancel)
+	p.nextID++
+
+	cfg := p.cfg.Config
+	cfg.workerID = p.nextID
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer p.stats.workers.Add(-1)
+		p.stats.workers.Add(1)
+		loop(ctx, cfg, &p.stats)
+	}()
+}
+
+// removeWorker stops the most recently added worker, once it has finished any work in progress.
+func (p *Pool) removeWorker() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	last := len(p.cancels) - 1
+	p.cancels[last]()
+	p.cancels = p.cancels[:last]
+}
+
+func (p *Pool) workers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.cancels)
+}
+
+// GaugeName returns the name for the gauges. (satisfies system.GaugeProducer)
+func (p *Pool) GaugeName() string {
+	return "worker-pool"
+}
+
+// Gauges returns the number of running, active and idle workers, tagged with the pool name.
+// (satisfies system.GaugeProducer)
+func (p *Pool) Gauges(_ context.Context) map[string][]gauge.TaggedValue {
+	workers := p.stats.workers.Load()
+	active := p.stats.active.Load()
+	tags := []string{"pool:" + p.cfg.Name}
+	return map[string][]gauge.TaggedValue{
+		"workers":        {{Val: float64(workers), Tags: tags}},
+		"active_workers": {{Val: float64(active), Tags: tags}},
+		"idle_workers":   {{Val: float64(max(workers-active, 0)), Tags: tags}},
+	}
+}
+
+type poolStats struct {
+	workers  atomic.Int64
+	active   atomic.Int64
+	loops    atomic.Int64
+	backoffs atomic.Int64
+}
+
+func (s *poolStats) startWork() {
+	if s == nil {
+		return
+	}
+	s.active.Add(1)
+}
+
+func (s *poolStats) endWork(backedOff bool) {
+	if s == nil {
+		return
+	}
+	s.active.Add(-1)
+	s.loops.Add(1)
+	if backedOff {
+		s.backoffs.Add(1)
+	}
+}
+
+func (s *poolStats) resetInterval() (loops, backoffs int64) {
+	return s.loops.Swap(0), s.backoffs.Swap(0)
+}
+
+// lockedBackOff allows a single back off policy to be shared by all the workers in a pool.
+type lockedBackOff struct {
+	mu sync.Mutex
+	b  backoff.BackOff
+}
+
+func (l *lockedBackOff) NextBackOff() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.NextBackOff()
+}
+
+func (l *lockedBackOff) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.b.Reset()
+}
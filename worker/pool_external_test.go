@@ -0,0 +1,9 @@
+package worker_test
+
+import (
+	"github.com/circleci/ex/system"
+	"github.com/circleci/ex/worker"
+)
+
+// the system package depends on worker, so this is checked from outside the package
+var _ system.GaugeProducer = (*worker.Pool)(nil)
@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+	"gotest.tools/v3/poll"
+
+	"github.com/circleci/ex/internal/gauge"
+	"github.com/circleci/ex/testing/testcontext"
+)
+
+func TestPool_RunsWorkersConcurrently(t *testing.T) {
+	ctx, cancel := context.WithCancel(testcontext.Background())
+	defer cancel()
+
+	const workers = 4
+	started := &sync.WaitGroup{}
+	started.Add(workers)
+	release := make(chan struct{})
+
+	p := NewPool(PoolConfig{
+		Config: Config{
+			Name:        "runs-workers-concurrently",
+			MaxWorkTime: time.Second,
+			WorkFunc: func(ctx context.Context) error {
+				started.Done()
+				<-release
+				return ErrShouldBackoff
+			},
+		},
+		MinWorkers: workers,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	// all workers must be in the work func at the same time for this wait to return
+	started.Wait()
+	tags := []string{"pool:runs-workers-concurrently"}
+	assert.Check(t, cmp.DeepEqual(p.Gauges(ctx), map[string][]gauge.TaggedValue{
+		"workers":        {{Val: workers, Tags: tags}},
+		"active_workers": {{Val: workers, Tags: tags}},
+		"idle_workers":   {{Val: 0, Tags: tags}},
+	}))
+
+	cancel()
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pool did not finish in time")
+	}
+	assert.Check(t, cmp.Equal(p.Gauges(ctx)["workers"][0].Val, float64(0)))
+}
+
+func TestPool_Scales(t *testing.T) {
+	ctx, cancel := context.WithCancel(testcontext.Background())
+	defer cancel()
+
+	var backoff atomic.Bool
+	p := NewPool(PoolConfig{
+		Config: Config{
+			Name:          "scales",
+			NoWorkBackOff: &fakeBackOff{nextBackOff: time.Millisecond},
+			WorkFunc: func(ctx context.Context) error {
+				time.Sleep(time.Millisecond)
+				if backoff.Load() {
+					return ErrShouldBackoff
+				}
+				return nil
+			},
+		},
+		MinWorkers:    1,
+		MaxWorkers:    3,
+		ScaleInterval: 20 * time.Millisecond,
+	})
+
+	go p.Run(ctx)
+
+	workers := func(want float64) func(t poll.LogT) poll.Result {
+		return func(t poll.LogT) poll.Result {
+			got := p.Gauges(ctx)["workers"][0].Val
+			if got != want {
+				return poll.Continue("got %v workers, want %v", got, want)
+			}
+			return poll.Success()
+		}
+	}
+
+	poll.WaitOn(t, workers(3), poll.WithTimeout(time.Second))
+
+	backoff.Store(true)
+	poll.WaitOn(t, workers(1), poll.WithTimeout(time.Second))
+}
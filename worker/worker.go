@@ -34,6 +34,8 @@ type Config struct {
 	BackoffOnAllErrors bool
 
 	waiter func(ctx context.Context, delay time.Duration)
+	// workerID identifies the worker within a Pool, it is zero for a worker started by Run.
+	workerID int
 }
 
 // Run a worker, which calls WorkFunc in a loop.
@@ -41,11 +43,18 @@ type Config struct {
 func Run(ctx context.Context, cfg Config) {
 	cfg = setDefaults(cfg)
 	cfg.NoWorkBackOff.Reset()
+	loop(ctx, cfg, nil)
+}
+
+// loop calls WorkFunc until the context is cancelled, recording each loop in the stats if not nil.
+func loop(ctx context.Context, cfg Config, stats *poolStats) {
 	provider := o11y.FromContext(ctx)
 
 	for ctx.Err() == nil {
 		start := time.Now()
+		stats.startWork()
 		wait := doWork(provider, cfg)
+		stats.endWork(wait >= 0)
 		if wait < 0 {
 			cfg.NoWorkBackOff.Reset()
 			// If the work took longer than the minimum we can continue the loop
@@ -99,6 +108,9 @@ func doWork(provider o11y.Provider, cfg Config) (backoff time.Duration) {
 	ctx, span := provider.StartSpan(ctx, fmt.Sprintf("worker loop: %s", cfg.Name))
 	o11y.AddFieldToTrace(ctx, "loop_name", cfg.Name)
 	span.AddRawField("meta.type", "worker_loop")
+	if cfg.workerID > 0 {
+		span.AddField("worker_id", cfg.workerID)
+	}
 
 	span.RecordMetric(o11y.Timing("worker_loop", "loop_name", "result"))
 